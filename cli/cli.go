@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"strings"
@@ -12,10 +13,40 @@ import (
 )
 
 type CLIModel struct {
-	tree  *Tree
-	width int
-	ctx   context.Context
-	log   zerolog.Logger
+	tree      *Tree
+	width     int
+	height    int
+	ctx       context.Context
+	log       zerolog.Logger
+	estimates map[string]*taskStats
+	events    *subscription
+	started   time.Time
+	// our copy of the state and output of every task, updated from events
+	views map[string]*taskView
+
+	// name of the task selected in the list
+	selected string
+	// whether the log viewport of the selected task is open
+	expanded bool
+	// first line of the log shown in the viewport
+	offset int
+	// keeps the viewport scrolled to the end of the log
+	follow    bool
+	searching bool
+	query     string
+	// once the user interacted, we keep running until they quit
+	interacted bool
+	done       bool
+	quitting   bool
+	// feedback for the last action
+	message string
+}
+
+// taskView is what the TUI knows about a task.
+type taskView struct {
+	name string
+	taskState
+	output *bytes.Buffer
 }
 
 type contextMsg struct{}
@@ -26,124 +57,438 @@ func waitForContext(ctx context.Context) tea.Cmd {
 	}
 }
 
-type refreshMsg time.Time
+type eventMsg struct{ event event }
+
+type eventsClosedMsg struct{}
+
+func waitForEvent(s *subscription) tea.Cmd {
+	return func() tea.Msg {
+		if e, ok := s.next(); ok {
+			return eventMsg{e}
+		}
+		return eventsClosedMsg{}
+	}
+}
+
+// clockMsg only updates the elapsed time shown for running tasks,
+// all changes of tasks arrive as events.
+type clockMsg time.Time
 
-func refresh() tea.Cmd {
+func clock() tea.Cmd {
 	return func() tea.Msg {
-		return refreshMsg(<-time.After(33 * time.Millisecond))
+		return clockMsg(<-time.After(100 * time.Millisecond))
 	}
 }
 
+func newCLIModel(tree *Tree, ctx context.Context, log zerolog.Logger) *CLIModel {
+	m := &CLIModel{
+		tree:    tree,
+		ctx:     ctx,
+		log:     log,
+		events:  tree.bus.subscribe(),
+		started: time.Now(),
+		views:   map[string]*taskView{},
+	}
+	for _, task := range tree.selectedTasks() {
+		m.views[task.name] = &taskView{name: task.name, output: &bytes.Buffer{}}
+	}
+	return m
+}
+
 func (m *CLIModel) Init() tea.Cmd {
-	return tea.Batch(refresh(), waitForContext(m.ctx))
+	return tea.Batch(clock(), waitForEvent(m.events), waitForContext(m.ctx))
+}
+
+func (m *CLIModel) handleEvent(e event) {
+	view, ok := m.views[e.taskName()]
+	if !ok {
+		return
+	}
+
+	switch e := e.(type) {
+	case stateEvent:
+		// every command starts with a fresh log, like in the summary
+		if e.state.stage != view.stage && (e.state.stage == "build" || e.state.stage == "run") {
+			view.output.Reset()
+			if view == m.selectedTask() {
+				m.offset = 0
+			}
+		}
+		view.taskState = e.state
+	case outputEvent:
+		view.output.Write(e.data)
+	}
 }
 
 func (m *CLIModel) Update(recv tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := recv.(type) {
 	case tea.KeyMsg:
+		if m.searching {
+			m.updateSearch(msg)
+			return m, nil
+		}
+
 		switch msg.String() {
-		case "q", "esc", "ctrl+c":
-			return m, tea.Quit
+		case "q", "ctrl+c":
+			return m, m.quit()
+		}
+
+		interacted := m.interacted
+		m.message = ""
+
+		if m.expanded {
+			m.updateLog(msg)
+		} else if msg.String() == "esc" {
+			return m, m.quit()
+		} else {
+			m.updateList(msg)
+		}
+
+		if m.interacted && !interacted {
+			m.tree.control(controlHold, "")
 		}
 	case contextMsg:
-		return m, tea.Quit
-	case refreshMsg:
-		return m, refresh()
+		m.done = true
+		if !m.interacted {
+			return m, m.quit()
+		}
+	case eventMsg:
+		m.handleEvent(msg.event)
+		return m, waitForEvent(m.events)
+	case eventsClosedMsg:
+	case clockMsg:
+		return m, clock()
 	case tea.WindowSizeMsg:
 		m.width = msg.Width
+		m.height = msg.Height
 	}
 
 	return m, nil
 }
 
+func (m *CLIModel) quit() tea.Cmd {
+	m.quitting = true
+	m.tree.control(controlRelease, "")
+	return tea.Quit
+}
+
+func (m *CLIModel) updateList(msg tea.KeyMsg) {
+	tasks := m.tasks()
+	index := m.selectedIndex(tasks)
+
+	switch msg.String() {
+	case "x":
+		m.sendControl(controlKill, "killing")
+	case "r":
+		m.sendControl(controlRestart, "restarting")
+	case "s":
+		m.sendControl(controlSkip, "skipping")
+	case "up", "k":
+		index--
+	case "down", "j":
+		index++
+	case "home", "g":
+		index = 0
+	case "end", "G":
+		index = len(tasks) - 1
+	case "enter", "right", "l":
+		m.openLog(true)
+	case "f":
+		m.openLog(true)
+	case "/":
+		m.searching = true
+		m.query = ""
+	default:
+		return
+	}
+
+	m.interacted = true
+
+	if len(tasks) > 0 {
+		index = (index + len(tasks)) % len(tasks)
+		m.selected = tasks[index].name
+	}
+}
+
+// sendControl applies an action to the selected task.
+func (m *CLIModel) sendControl(action, verb string) {
+	if task := m.selectedTask(); task != nil {
+		m.tree.control(action, task.name)
+		m.message = fmt.Sprintf("%s %s", verb, task.name)
+	}
+}
+
+func (m *CLIModel) openLog(follow bool) {
+	m.expanded = true
+	m.follow = follow
+	m.offset = 0
+}
+
+func (m *CLIModel) updateLog(msg tea.KeyMsg) {
+	m.interacted = true
+	page := m.logHeight()
+
+	switch msg.String() {
+	case "esc", "enter", "left", "h":
+		m.expanded = false
+		m.follow = false
+	case "up", "k":
+		m.scroll(-1)
+	case "down", "j":
+		m.scroll(1)
+	case "pgup", "b":
+		m.scroll(-page)
+	case "pgdown", " ":
+		m.scroll(page)
+	case "home", "g":
+		m.follow = false
+		m.offset = 0
+	case "end", "G":
+		m.follow = true
+	case "f":
+		m.follow = !m.follow
+	case "/":
+		m.searching = true
+		m.query = ""
+	case "n":
+		m.findMatch(m.offset+1, 1)
+	case "N":
+		m.findMatch(m.offset-1, -1)
+	}
+}
+
+func (m *CLIModel) updateSearch(msg tea.KeyMsg) {
+	switch msg.Type {
+	case tea.KeyEsc, tea.KeyCtrlC:
+		m.searching = false
+		m.query = ""
+	case tea.KeyEnter:
+		m.searching = false
+		if !m.expanded {
+			m.openLog(false)
+		}
+		m.findMatch(m.offset, 1)
+	case tea.KeyBackspace:
+		if len(m.query) > 0 {
+			runes := []rune(m.query)
+			m.query = string(runes[:len(runes)-1])
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		m.query += string(msg.Runes)
+	}
+}
+
+// scroll moves the viewport by delta lines and stops following the log.
+func (m *CLIModel) scroll(delta int) {
+	lines := m.logLines(m.selectedTask())
+	if m.follow {
+		m.offset = m.maxOffset(lines)
+		m.follow = false
+	}
+
+	m.offset += delta
+	if limit := m.maxOffset(lines); m.offset > limit {
+		m.offset = limit
+	}
+	if m.offset < 0 {
+		m.offset = 0
+	}
+}
+
+// findMatch scrolls to the next line containing the query,
+// starting at the given line and going into the given direction.
+func (m *CLIModel) findMatch(from, direction int) {
+	if m.query == "" {
+		return
+	}
+
+	lines := m.logLines(m.selectedTask())
+	query := strings.ToLower(m.query)
+	for i, n := from, 0; n < len(lines); i, n = i+direction, n+1 {
+		i = (i + len(lines)) % len(lines)
+		if strings.Contains(strings.ToLower(lines[i]), query) {
+			m.follow = false
+			m.offset = i
+			return
+		}
+	}
+}
+
+// tasks returns the tasks that started, ordered by name.
+func (m *CLIModel) tasks() []*taskView {
+	tasks := []*taskView{}
+	for _, task := range m.tree.selectedTasks() {
+		if view := m.views[task.name]; view.stage != "" {
+			tasks = append(tasks, view)
+		}
+	}
+	return tasks
+}
+
+// idle is true once all tasks finished.
+func (m *CLIModel) idle() bool {
+	for _, view := range m.views {
+		switch view.stage {
+		case "done", "error", "cancel", "skip":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (m *CLIModel) selectedIndex(tasks []*taskView) int {
+	for i, task := range tasks {
+		if task.name == m.selected {
+			return i
+		}
+	}
+	return 0
+}
+
+func (m *CLIModel) selectedTask() *taskView {
+	tasks := m.tasks()
+	if len(tasks) == 0 {
+		return nil
+	}
+	return tasks[m.selectedIndex(tasks)]
+}
+
+func (m *CLIModel) logLines(task *taskView) []string {
+	if task == nil || task.output.Len() == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimRight(task.output.String(), "\n"), "\n")
+}
+
+func (m *CLIModel) viewHeight() int {
+	if m.height > 0 {
+		return m.height
+	}
+	return 24
+}
+
+// logHeight is the number of log lines that fit into the viewport.
+func (m *CLIModel) logHeight() int {
+	if h := m.viewHeight() - 2; h > 1 {
+		return h
+	}
+	return 1
+}
+
+func (m *CLIModel) maxOffset(lines []string) int {
+	if limit := len(lines) - m.logHeight(); limit > 0 {
+		return limit
+	}
+	return 0
+}
+
 var (
 	green = lipgloss.Color("#a8cc8c")
 	blue  = lipgloss.Color("#73bef3")
 	red   = lipgloss.Color("#e88388")
 	teal  = lipgloss.Color("#73bef3")
+	grey  = lipgloss.Color("#b9c0cb")
+	dim   = lipgloss.Color("#5c6370")
+	mark  = lipgloss.Color("#3e4451")
 )
 
 func (m *CLIModel) View() string {
-	taskNameLen := 0
-	tasks := map[string]*Task{}
-	for _, taskName := range m.tree.taskNames {
-		vert, err := m.tree.dag.GetVertex(taskName)
-		if err != nil {
-			// TODO: proper error handling
-			m.log.Fatal().Err(err).Str("task", taskName).Msg("missing vertex")
+	switch {
+	case m.quitting:
+		return m.summaryView()
+	case m.expanded:
+		return m.logView()
+	default:
+		return m.splitView()
+	}
+}
+
+// taskLine renders the status of a task in a single line of the given width.
+func (m *CLIModel) taskLine(task *taskView, taskNameLen, width int) string {
+	startTime, endTime := task.startedAt(), time.Now()
+	if !task.runStart.IsZero() {
+		endTime = task.runEnd
+	} else if !task.buildStart.IsZero() {
+		endTime = task.buildEnd
+	} else if startTime.IsZero() {
+		startTime = endTime
+	}
+	if endTime.IsZero() {
+		endTime = time.Now()
+	}
+
+	duration := endTime.Sub(startTime)
+
+	var line, durationOut string
+	var color lipgloss.Color
+	switch task.stage {
+	case "wait":
+		color = blue
+		line = fmt.Sprintf("[%s] %-6s %s", "+", task.stage, task.name)
+		durationOut = fmt.Sprintf("%3.1fs", duration.Seconds())
+	case "eval", "build", "run":
+		color = teal
+		line = fmt.Sprintf("[%s] %-6s %s", "+", task.stage, task.name)
+		durationOut = fmt.Sprintf("%3.1fs", duration.Seconds())
+		if eta := stageETA(m.estimates, task.name, task.taskState, endTime); eta != "" {
+			durationOut += " " + eta
 		}
-		tasks[taskName] = vert.Value.(*Task)
-		if len(taskName) > taskNameLen {
-			taskNameLen = len(taskName)
+	case "error":
+		color = red
+		line = fmt.Sprintf("[%s] %-6s %s", "✗", task.stage, task.name)
+		durationOut = duration.String()
+	case "cancel":
+		color = teal
+		line = fmt.Sprintf("[%s] %-6s %s", "✗", task.stage, task.name)
+		durationOut = "0.0s"
+	case "done":
+		color = green
+		line = fmt.Sprintf("[%s] %-6s %s", "✔", task.stage, task.name)
+		durationOut = duration.String()
+	case "skip":
+		color = dim
+		line = fmt.Sprintf("[%s] %-6s %s", "-", task.stage, task.name)
+		durationOut = "0.0s"
+	}
+
+	timestamp := lipgloss.NewStyle().Margin(0, 0, 0, 2).Render(durationOut)
+	leftWidth := min(width-lipgloss.Width(timestamp), taskNameLen+11)
+
+	return lipgloss.NewStyle().Width(width).MaxWidth(width).Foreground(color).Render(
+		lipgloss.JoinHorizontal(
+			lipgloss.Top,
+			lipgloss.NewStyle().Width(leftWidth).Render(line),
+			timestamp,
+		),
+	)
+}
+
+func taskNameLen(tasks []*taskView) int {
+	n := 0
+	for _, task := range tasks {
+		if len(task.name) > n {
+			n = len(task.name)
 		}
 	}
+	return n
+}
 
+// summaryView is the final output, showing the logs of failed tasks.
+func (m *CLIModel) summaryView() string {
+	tasks := m.tasks()
+	nameLen := taskNameLen(tasks)
 	styleLine := lipgloss.NewStyle().Width(m.width).MaxWidth(m.width)
-	styleDuration := lipgloss.NewStyle().Margin(0, 0, 0, 2)
 
 	lines := []string{}
-	for _, taskName := range m.tree.taskNames {
-		task := tasks[taskName]
-		if task.stage == "" {
-			continue
-		}
+	for _, task := range tasks {
+		lines = append(lines, m.taskLine(task, nameLen, m.width))
 
-		var startTime, endTime time.Time
-		if !task.runStart.IsZero() {
-			startTime, endTime = task.buildStart, task.runEnd
-		} else if !task.buildStart.IsZero() {
-			startTime, endTime = task.buildStart, task.buildEnd
-		} else {
-			startTime, endTime = time.Now(), time.Now()
-		}
-		if endTime.IsZero() {
-			endTime = time.Now()
-		}
-
-		duration := endTime.Sub(startTime)
-
-		var line, durationOut string
-		var color lipgloss.Color
-		switch task.stage {
-		case "wait":
-			color = blue
-			line = fmt.Sprintf("[%s] %-6s %s", "+", task.stage, taskName)
-			durationOut = fmt.Sprintf("%3.1fs", duration.Seconds())
-		case "build", "run":
-			color = teal
-			line = fmt.Sprintf("[%s] %-6s %s", "+", task.stage, taskName)
-			durationOut = fmt.Sprintf("%3.1fs", duration.Seconds())
-		case "error":
-			color = red
-			line = fmt.Sprintf("[%s] %-6s %s", "✗", task.stage, taskName)
-			durationOut = duration.String()
-		case "cancel":
-			color = teal
-			line = fmt.Sprintf("[%s] %-6s %s", "✗", task.stage, taskName)
-			durationOut = "0.0s"
-		case "done":
-			color = green
-			line = fmt.Sprintf("[%s] %-6s %s", "✔", task.stage, taskName)
-			durationOut = duration.String()
-		}
-
-		timestamp := styleDuration.Render(durationOut)
-		width := min(m.width-lipgloss.Width(timestamp), taskNameLen+11)
-		styleLeft := lipgloss.NewStyle().Width(width)
-
-		lines = append(lines, styleLine.Foreground(color).Render(
-			lipgloss.JoinHorizontal(
-				lipgloss.Top,
-				styleLeft.Render(line),
-				timestamp,
-			),
-		))
-
-		if task.cliLines != nil {
+		if task.output.Len() > 0 {
 			switch task.stage {
 			case "error", "run":
 				logLength := 10
-				all := strings.Split(task.cliLines.String(), "\n")
+				all := strings.Split(task.output.String(), "\n")
 				if task.stage == "error" {
 					logLength = len(all)
 				}
@@ -152,7 +497,7 @@ func (m *CLIModel) View() string {
 				for _, line := range all[n:] {
 					l := strings.TrimSpace(line)
 					if l != "" {
-						lines = append(lines, styleLine.Foreground(lipgloss.Color("#b9c0cb")).Render(l))
+						lines = append(lines, styleLine.Foreground(grey).Render(l))
 					}
 				}
 			}
@@ -163,6 +508,121 @@ func (m *CLIModel) View() string {
 		}
 	}
 
+	if progress := m.progressView(); progress != "" && !m.done {
+		lines = append(lines, "", styleLine.Render(progress))
+	}
+
 	out := lipgloss.JoinVertical(0, lines...)
 	return lipgloss.NewStyle().Height(lipgloss.Height(out) + 1).Render(out)
 }
+
+// splitView shows the list of tasks next to the output of the selected task.
+func (m *CLIModel) splitView() string {
+	tasks := m.tasks()
+	nameLen := taskNameLen(tasks)
+	selected := m.selectedIndex(tasks)
+	height := m.viewHeight() - 2
+
+	leftWidth := min(nameLen+11+18, m.width/2)
+	rightWidth := m.width - leftWidth - 3
+
+	// keep the selected task in view if the list is too long
+	first := 0
+	if selected >= height {
+		first = selected - height + 1
+	}
+
+	left := []string{}
+	for i := first; i < len(tasks) && len(left) < height; i++ {
+		line := m.taskLine(tasks[i], nameLen, leftWidth)
+		if i == selected {
+			line = lipgloss.NewStyle().Background(mark).Render(line)
+		}
+		left = append(left, line)
+	}
+
+	right := []string{}
+	if rightWidth > 0 && len(tasks) > 0 {
+		styleLog := lipgloss.NewStyle().MaxWidth(rightWidth).Foreground(grey)
+		lines := m.logLines(tasks[selected])
+		for _, line := range lines[len(lines)-min(len(lines), height):] {
+			right = append(right, styleLog.Render(line))
+		}
+	}
+
+	separator := lipgloss.NewStyle().Foreground(dim).Render(strings.Repeat("│\n", max(max(len(left), len(right)), 1)-1) + "│")
+
+	body := lipgloss.JoinHorizontal(
+		lipgloss.Top,
+		lipgloss.NewStyle().Width(leftWidth).Render(strings.Join(left, "\n")),
+		" "+separator+" ",
+		strings.Join(right, "\n"),
+	)
+
+	return lipgloss.JoinVertical(0, body, m.statusLine(tasks, "↑/↓ select • enter open log • f follow • / search • x kill • r restart • s skip • q quit"))
+}
+
+// logView is a scrollable viewport showing the full log of the selected task.
+func (m *CLIModel) logView() string {
+	task := m.selectedTask()
+	if task == nil {
+		return ""
+	}
+
+	lines := m.logLines(task)
+	height := m.logHeight()
+	if limit := m.maxOffset(lines); m.follow || m.offset > limit {
+		m.offset = limit
+	}
+	last := min(m.offset+height, len(lines))
+
+	follow := ""
+	if m.follow {
+		follow = " [follow]"
+	}
+	header := lipgloss.NewStyle().Bold(true).MaxWidth(m.width).Render(
+		fmt.Sprintf("%s (%s) lines %d-%d of %d%s", task.name, task.stage, min(m.offset+1, last), last, len(lines), follow))
+
+	query := strings.ToLower(m.query)
+	styleLog := lipgloss.NewStyle().MaxWidth(m.width).Foreground(grey)
+	styleMatch := styleLog.Copy().Background(mark).Foreground(lipgloss.Color("#e5c07b"))
+
+	body := []string{header}
+	for _, line := range lines[m.offset:last] {
+		if query != "" && strings.Contains(strings.ToLower(line), query) {
+			body = append(body, styleMatch.Render(line))
+		} else {
+			body = append(body, styleLog.Render(line))
+		}
+	}
+	for len(body) <= height {
+		body = append(body, "")
+	}
+
+	return lipgloss.JoinVertical(0,
+		strings.Join(body, "\n"),
+		m.statusLine(m.tasks(), "↑/↓ scroll • / search • n/N next/prev match • f follow • esc back • q quit"),
+	)
+}
+
+// statusLine shows the search prompt, progress, or the given help.
+func (m *CLIModel) statusLine(tasks []*taskView, help string) string {
+	style := lipgloss.NewStyle().MaxWidth(m.width)
+
+	if m.searching {
+		return style.Render("/" + m.query + "█")
+	}
+
+	parts := []string{}
+	if m.message != "" {
+		parts = append(parts, m.message)
+	}
+	if m.done || m.idle() {
+		parts = append(parts, "finished")
+	} else if progress := m.progressView(); progress != "" {
+		parts = append(parts, progress)
+	}
+	parts = append(parts, lipgloss.NewStyle().Foreground(dim).Render(help))
+
+	return style.Render(strings.Join(parts, "  "))
+}
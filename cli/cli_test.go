@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestLogViewAfterOutputReset(t *testing.T) {
+	tree := newTestTree(t, Run{Task: "top"}, `{"version": 1, "tasks": {
+		"a": {"after": [], "bin": "/bin/true"},
+		"top": {"after": ["a"], "bin": "/bin/true"}
+	}}`)
+	m := newCLIModel(tree, context.Background(), zerolog.Nop())
+	m.width, m.height = 80, 10
+
+	m.handleEvent(stateEvent{task: "a", state: taskState{stage: "run"}})
+	for i := 0; i < 100; i++ {
+		m.handleEvent(outputEvent{task: "a", stream: "out", data: []byte(fmt.Sprintf("line %d\n", i))})
+	}
+
+	m.selected = "a"
+	m.openLog(false)
+	m.scroll(50)
+	if m.offset != 50 {
+		t.Fatalf("expected offset 50, got %d", m.offset)
+	}
+
+	// restarting the task starts a fresh log
+	m.handleEvent(stateEvent{task: "a", state: taskState{stage: "error"}})
+	m.handleEvent(stateEvent{task: "a", state: taskState{stage: "run"}})
+	m.handleEvent(outputEvent{task: "a", stream: "out", data: []byte("again\n")})
+
+	if view := m.View(); !strings.Contains(view, "again") {
+		t.Errorf("expected the new output in the log view, got:\n%s", view)
+	}
+	if m.offset != 0 {
+		t.Errorf("expected offset 0 after the output was reset, got %d", m.offset)
+	}
+
+	// the log may also shrink while another task is shown
+	m.offset = 1000
+	if view := m.View(); !strings.Contains(view, "again") {
+		t.Errorf("expected the offset to be clamped to the log, got:\n%s", view)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The completion scripts ask tullia itself for candidates,
+// so they always match the flags and subcommands of the installed version.
+const (
+	completionBash = `_tullia() {
+  local IFS=$'\n'
+  COMPREPLY=($(tullia completion --complete -- "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F _tullia tullia
+`
+	completionZsh = `#compdef tullia
+_tullia() {
+  local -a candidates
+  candidates=(${(f)"$(tullia completion --complete -- "${(@)words[2,CURRENT]}" 2>/dev/null)"})
+  if (( ${#candidates} )); then
+    compadd -a candidates
+  else
+    _files
+  fi
+}
+if [ "$funcstack[1]" = "_tullia" ]; then
+  _tullia "$@"
+else
+  compdef _tullia tullia
+fi
+`
+	completionFish = `function __tullia_complete
+  set -l tokens (commandline -opc) (commandline -ct)
+  tullia completion --complete -- $tokens[2..-1] 2>/dev/null
+end
+complete -c tullia -f -a '(__tullia_complete)'
+`
+)
+
+func (c Completion) start() error {
+	if c.Complete {
+		for _, candidate := range c.candidates(c.Args) {
+			fmt.Fprintln(os.Stdout, candidate)
+		}
+		return nil
+	}
+
+	if len(c.Args) != 1 {
+		return fmt.Errorf("Expected exactly one shell, one of bash,zsh,fish")
+	}
+
+	switch c.Args[0] {
+	case "bash":
+		fmt.Fprint(os.Stdout, completionBash)
+	case "zsh":
+		fmt.Fprint(os.Stdout, completionZsh)
+	case "fish":
+		fmt.Fprint(os.Stdout, completionFish)
+	default:
+		return fmt.Errorf("Unknown shell: %q", c.Args[0])
+	}
+	return nil
+}
+
+// completionFlag is a flag of a command as declared in its arg struct tag.
+type completionFlag struct {
+	names    []string
+	hasValue bool
+	values   []string
+}
+
+type completionCommand struct {
+	flags       []completionFlag
+	positionals []reflect.StructField
+	subcommands map[string]reflect.Type
+}
+
+var oneOf = regexp.MustCompile(`one of ([\w,-]+)`)
+
+// completionSpec reads the flags, positionals and subcommands of an arg struct.
+func completionSpec(t reflect.Type) completionCommand {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	cmd := completionCommand{subcommands: map[string]reflect.Type{}}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, ok := field.Tag.Lookup("arg")
+		if !ok || field.PkgPath != "" {
+			continue
+		}
+
+		flag := completionFlag{hasValue: field.Type.Kind() != reflect.Bool}
+		if match := oneOf.FindStringSubmatch(field.Tag.Get("help")); match != nil {
+			flag.values = strings.Split(match[1], ",")
+		}
+
+		for _, part := range strings.Split(tag, ",") {
+			switch {
+			case part == "positional":
+				cmd.positionals = append(cmd.positionals, field)
+			case strings.HasPrefix(part, "subcommand:"):
+				cmd.subcommands[strings.TrimPrefix(part, "subcommand:")] = field.Type
+			case strings.HasPrefix(part, "-"):
+				flag.names = append(flag.names, part)
+			}
+		}
+
+		if len(flag.names) > 0 {
+			cmd.flags = append(cmd.flags, flag)
+		}
+	}
+
+	cmd.flags = append(cmd.flags, completionFlag{names: []string{"--help", "-h"}})
+	return cmd
+}
+
+func (cmd completionCommand) flag(name string) (completionFlag, bool) {
+	for _, flag := range cmd.flags {
+		for _, n := range flag.names {
+			if n == name {
+				return flag, true
+			}
+		}
+	}
+	return completionFlag{}, false
+}
+
+// candidates returns the possible completions of the last of the given words.
+func (c Completion) candidates(words []string) []string {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	current := words[len(words)-1]
+
+	cmd := completionSpec(reflect.TypeOf(Config{}))
+	positionals := 0
+	var expecting *completionFlag
+	// values of the flags given so far, by the name they were given with
+	values := map[string]string{}
+	expectingName := ""
+
+	for _, word := range words[:len(words)-1] {
+		switch {
+		case expecting != nil:
+			values[expectingName] = word
+			expecting = nil
+		case word == "--":
+		case strings.HasPrefix(word, "-"):
+			name, value := word, ""
+			i := strings.Index(word, "=")
+			if i >= 0 {
+				name, value = word[:i], word[i+1:]
+			}
+			if flag, ok := cmd.flag(name); ok && flag.hasValue {
+				if i >= 0 {
+					values[name] = value
+				} else {
+					expecting, expectingName = &flag, name
+				}
+			}
+		case cmd.subcommands[word] != nil:
+			cmd = completionSpec(cmd.subcommands[word])
+			positionals = 0
+		default:
+			positionals++
+		}
+	}
+
+	candidates := []string{}
+	switch {
+	case expecting != nil:
+		candidates = expecting.values
+	case strings.HasPrefix(current, "-"):
+		for _, flag := range cmd.flags {
+			candidates = append(candidates, flag.names...)
+		}
+	case len(cmd.subcommands) > 0:
+		for name := range cmd.subcommands {
+			candidates = append(candidates, name)
+		}
+	case positionals < len(cmd.positionals):
+		field := cmd.positionals[positionals]
+		if field.Tag.Get("complete") == "task" {
+			candidates = c.taskNames(values)
+		} else if match := oneOf.FindStringSubmatch(field.Tag.Get("help")); match != nil {
+			candidates = strings.Split(match[1], ",")
+		}
+	}
+
+	matching := []string{}
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, current) {
+			matching = append(matching, candidate)
+		}
+	}
+	sort.Strings(matching)
+	return matching
+}
+
+// taskNames returns the names of all tasks, usually from the evaluation cache.
+// The flake and state directory given on the command line being completed take precedence.
+func (c Completion) taskNames(values map[string]string) []string {
+	dagFlake, stateDir := c.DagFlake, c.StateDir
+	if value, ok := values["--dag-flake"]; ok {
+		dagFlake = value
+	}
+	if value, ok := values["--state-dir"]; ok {
+		stateDir = value
+	}
+
+	dag, err := cachedDag(dagFlake, stateDir, false)
+	if err != nil {
+		return nil
+	}
+	return taskGraph(dag).names()
+}
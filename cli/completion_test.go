@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fakeNix puts a nix on the PATH that evaluates every flake to the given DAGs
+// and fails for anything else. It returns the file its arguments are logged to.
+// Flake metadata is only known if FAKE_NIX_REV is set, which is the locked revision.
+func fakeNix(t *testing.T, dags map[string]string) (calls string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	calls = filepath.Join(dir, "calls")
+	script := "#!/bin/sh\necho \"$@\" >> '" + calls + "'\n" +
+		"if [ \"$1 $2\" = \"flake metadata\" ] && [ -n \"$FAKE_NIX_REV\" ]; then\n" +
+		"  echo \"{\\\"locked\\\": {\\\"rev\\\": \\\"$FAKE_NIX_REV\\\"}}\"; exit 0\nfi\n" +
+		"[ \"$1 $2\" = \"eval --json\" ] || exit 1\ncase \"$3\" in\n"
+	for flake, dag := range dags {
+		script += "'" + flake + "') echo '" + dag + "' ;;\n"
+	}
+	script += "*) exit 1 ;;\nesac\n"
+
+	if err := os.WriteFile(filepath.Join(dir, "nix"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return calls
+}
+
+func TestCompleteTasksOfGivenFlake(t *testing.T) {
+	fakeNix(t, map[string]string{
+		"default#dag": `{"build": [], "bump": []}`,
+		"other#dag":   `{"deploy": [], "docs": []}`,
+	})
+	c := Completion{DagFlake: "default#dag", StateDir: t.TempDir()}
+
+	for _, tc := range []struct {
+		words    []string
+		expected []string
+	}{
+		{[]string{"run", "b"}, []string{"build", "bump"}},
+		{[]string{"run", "--dag-flake", "other#dag", ""}, []string{"deploy", "docs"}},
+		{[]string{"list", "--dag-flake=other#dag", "de"}, []string{"deploy"}},
+		{[]string{"run", "--dag-flake", ""}, []string{}},
+	} {
+		if got := c.candidates(tc.words); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("expected %v for %v, got %v", tc.expected, tc.words, got)
+		}
+	}
+}
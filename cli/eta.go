@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// estimatedStages returns the expected build and run duration of a task
+// according to its history, and whether there is any history.
+func estimatedStages(estimates map[string]*taskStats, taskName string) (build, run time.Duration, ok bool) {
+	s, ok := estimates[taskName]
+	if !ok || s.MedianRun == 0 {
+		return 0, 0, false
+	}
+	seconds := func(s float64) time.Duration { return time.Duration(s * float64(time.Second)) }
+	return seconds(s.MedianBuild), seconds(s.MedianRun), true
+}
+
+func remaining(expected time.Duration, start time.Time, now time.Time) time.Duration {
+	if left := expected - now.Sub(start); left > 0 {
+		return left
+	}
+	return 0
+}
+
+// remainingStages estimates how much longer the build and run of a task will take.
+func remainingStages(estimates map[string]*taskStats, taskName string, task taskState, prebuilt bool, now time.Time) (build, run time.Duration, ok bool) {
+	switch task.stage {
+	case "done", "error", "cancel", "skip":
+		return 0, 0, true
+	}
+
+	build, run, ok = estimatedStages(estimates, taskName)
+
+	switch {
+	case prebuilt || !task.buildEnd.IsZero():
+		build = 0
+	case !task.startedAt().IsZero():
+		build = remaining(build, task.startedAt(), now)
+	}
+
+	if !task.runStart.IsZero() {
+		run = remaining(run, task.runStart, now)
+	}
+
+	return build, run, ok
+}
+
+// stageETA describes the time left for the current stage of a running task.
+func stageETA(estimates map[string]*taskStats, taskName string, task taskState, now time.Time) string {
+	build, run, ok := estimatedStages(estimates, taskName)
+	if !ok {
+		return ""
+	}
+
+	var left time.Duration
+	switch task.stage {
+	case "eval", "build":
+		left = remaining(build, task.startedAt(), now)
+	case "run":
+		left = remaining(run, task.runStart, now)
+	default:
+		return ""
+	}
+
+	if left == 0 {
+		return "overdue"
+	}
+	return fmt.Sprintf("~%s left", left.Round(time.Second))
+}
+
+// eta estimates the time until all tasks are done along the remaining critical path.
+// It is only a lower bound if some tasks have no history.
+func eta(estimates map[string]*taskStats, tasks []*Task, states map[string]*taskView, now time.Time) (time.Duration, bool) {
+	complete := true
+	build := map[string]time.Duration{}
+	run := map[string]time.Duration{}
+	for _, task := range tasks {
+		// tasks without a view haven't started yet
+		state := taskState{}
+		if view, ok := states[task.name]; ok {
+			state = view.taskState
+		}
+		prebuilt := task.prebuilt() != ""
+		b, r, ok := remainingStages(estimates, task.name, state, prebuilt, now)
+		complete = complete && ok
+		build[task.name], run[task.name] = b, r
+	}
+
+	total, _ := criticalPath(tasks,
+		func(t *Task) time.Duration { return build[t.name] },
+		func(t *Task) time.Duration { return run[t.name] },
+	)
+
+	return total, complete
+}
+
+// progressView renders a progress bar with the ETA of the whole run.
+func (m *CLIModel) progressView() string {
+	if len(m.estimates) == 0 || m.idle() {
+		return ""
+	}
+
+	now := time.Now()
+	left, complete := eta(m.estimates, m.tree.selectedTasks(), m.views, now)
+	elapsed := now.Sub(m.started)
+
+	progress := 1.0
+	if total := elapsed + left; total > 0 {
+		progress = float64(elapsed) / float64(total)
+	}
+
+	const barWidth = 30
+	filled := int(progress * barWidth)
+	bar := strings.Repeat("█", filled) + strings.Repeat("░", barWidth-filled)
+
+	etaOut := left.Round(time.Second).String()
+	if !complete {
+		etaOut = ">" + etaOut
+	}
+
+	return fmt.Sprintf("%s %3.0f%%  ETA %s", bar, progress*100, etaOut)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestETA(t *testing.T) {
+	// b runs after a
+	tree := newTestTree(t, Run{Task: "b"}, `{"version": 1, "tasks": {
+		"a": {"after": []},
+		"b": {"after": ["a"]}
+	}}`)
+	tasks := tree.selectedTasks()
+
+	now := time.Now()
+	estimates := map[string]*taskStats{
+		"a": {Task: "a", MedianBuild: 10, MedianRun: 20},
+		"b": {Task: "b", MedianBuild: 40, MedianRun: 5},
+	}
+	view := func(name string, state taskState) *taskView {
+		return &taskView{name: name, taskState: state}
+	}
+
+	for _, tc := range []struct {
+		name      string
+		estimates map[string]*taskStats
+		states    map[string]*taskView
+		expected  time.Duration
+		complete  bool
+	}{
+		{
+			name:      "not started",
+			estimates: estimates,
+			states:    map[string]*taskView{"a": view("a", taskState{}), "b": view("b", taskState{})},
+			// b builds longer than a builds and runs
+			expected: 45 * time.Second,
+			complete: true,
+		},
+		{
+			name:      "without views",
+			estimates: estimates,
+			states:    map[string]*taskView{},
+			expected:  45 * time.Second,
+			complete:  true,
+		},
+		{
+			name:      "running",
+			estimates: estimates,
+			states: map[string]*taskView{
+				"a": view("a", taskState{stage: "run", buildStart: now.Add(-20 * time.Second), buildEnd: now.Add(-15 * time.Second), runStart: now.Add(-15 * time.Second)}),
+				"b": view("b", taskState{stage: "wait", buildStart: now.Add(-20 * time.Second), buildEnd: now.Add(-1 * time.Second)}),
+			},
+			expected: 10 * time.Second,
+			complete: true,
+		},
+		{
+			name:      "overdue",
+			estimates: estimates,
+			states: map[string]*taskView{
+				"a": view("a", taskState{stage: "done"}),
+				"b": view("b", taskState{stage: "run", buildEnd: now.Add(-time.Minute), runStart: now.Add(-time.Minute)}),
+			},
+			expected: 0,
+			complete: true,
+		},
+		{
+			name:      "without history",
+			estimates: map[string]*taskStats{"a": estimates["a"]},
+			states:    map[string]*taskView{},
+			expected:  30 * time.Second,
+			complete:  false,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, complete := eta(tc.estimates, tasks, tc.states, now)
+			if got != tc.expected || complete != tc.complete {
+				t.Errorf("expected %s (complete %t), got %s (complete %t)", tc.expected, tc.complete, got, complete)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// evalCacheSize is the number of evaluations kept, e.g. one per branch worked on.
+const evalCacheSize = 20
+
+// cachedDag evaluates the DAG like parseDag, but reuses the result of an
+// earlier evaluation of the same source.
+// Sources that can't be fingerprinted are always evaluated.
+func cachedDag(dagFlake, stateDir string, refresh bool) (map[string][]string, error) {
+	dag := map[string][]string{}
+	err := cachedEval(dagFlake, "", stateDir, refresh, &dag, func() (err error) {
+		dag, err = parseDag(dagFlake)
+		return err
+	})
+	return dag, err
+}
+
+// cachedRuntimes evaluates the runtime of every task like parseRuntimes,
+// cached like cachedDag.
+func cachedRuntimes(taskFlake, stateDir string, refresh bool) (map[string]string, error) {
+	runtimes := map[string]string{}
+	err := cachedEval(taskFlake, "runtimes", stateDir, refresh, &runtimes, func() (err error) {
+		runtimes, err = parseRuntimes(taskFlake)
+		return err
+	})
+	return runtimes, err
+}
+
+// cachedEval decodes the cached result of an evaluation of the installable into result,
+// or calls eval to fill it and caches it afterwards.
+// The name tells apart different evaluations of the same installable.
+func cachedEval(installable, name, stateDir string, refresh bool, result interface{}, eval func() error) error {
+	key, err := flakeFingerprint(installable)
+	if err != nil || key == "" {
+		return eval()
+	}
+	if name != "" {
+		key += "-" + name
+	}
+
+	dir := filepath.Join(stateDir, "eval")
+	path := filepath.Join(dir, key+".json")
+
+	if !refresh {
+		if content, err := os.ReadFile(path); err == nil {
+			if err := json.Unmarshal(content, result); err == nil {
+				return nil
+			}
+		}
+	}
+
+	if err := eval(); err != nil {
+		return err
+	}
+
+	if err := writeEvalCache(dir, path, result); err != nil {
+		// the cache is an optimization only
+		fmt.Fprintf(os.Stderr, "Not caching evaluation: %s\n", err)
+	}
+
+	return nil
+}
+
+func writeEvalCache(dir, path string, result interface{}) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	return pruneEvalCache(dir)
+}
+
+// pruneEvalCache removes all but the most recently written evaluations.
+func pruneEvalCache(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	type cached struct {
+		path    string
+		modTime int64
+	}
+	files := []cached{}
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && !entry.IsDir() {
+			files = append(files, cached{filepath.Join(dir, entry.Name()), info.ModTime().UnixNano()})
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime > files[j].modTime })
+	for i := evalCacheSize; i < len(files); i++ {
+		if err := os.Remove(files[i].path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// flakeFingerprint identifies the exact source an installable is evaluated from.
+// Local flakes in a git repository are identified by the tree of HEAD and
+// the content of every tracked file that changed since, as nix ignores untracked files.
+// Other flakes are identified by their locked reference.
+// An empty fingerprint means the source can't be identified.
+func flakeFingerprint(installable string) (string, error) {
+	ref := installable
+	if i := strings.Index(ref, "#"); i >= 0 {
+		ref = ref[:i]
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00", installable)
+
+	if dir, ok := localFlakeDir(ref); ok {
+		if err := gitFingerprint(hash, dir); err != nil {
+			return "", err
+		}
+	} else {
+		out, err := exec.Command("nix", "flake", "metadata", "--json", ref).Output()
+		if err != nil {
+			return "", errors.WithMessage(err, "getting flake metadata")
+		}
+
+		metadata := struct {
+			Locked json.RawMessage `json:"locked"`
+		}{}
+		if err := json.Unmarshal(out, &metadata); err != nil {
+			return "", errors.WithMessage(err, "parsing flake metadata")
+		}
+		if len(metadata.Locked) == 0 {
+			return "", nil
+		}
+		hash.Write(metadata.Locked)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// localFlakeDir returns the directory of flake references to a local git repository.
+// path: references include untracked files, so they can't be fingerprinted with git.
+func localFlakeDir(ref string) (string, bool) {
+	switch {
+	case ref == "":
+		return ".", true
+	case strings.HasPrefix(ref, "git+file://"):
+		return strings.TrimPrefix(ref, "git+file://"), true
+	case strings.HasPrefix(ref, "."), strings.HasPrefix(ref, "/"):
+		return ref, true
+	default:
+		return "", false
+	}
+}
+
+func gitFingerprint(w io.Writer, dir string) error {
+	git := func(args ...string) ([]byte, error) {
+		return exec.Command("git", append([]string{"-C", dir}, args...)...).Output()
+	}
+
+	root, err := git("rev-parse", "--show-toplevel")
+	if err != nil {
+		return errors.WithMessage(err, "finding git repository")
+	}
+	top := string(bytes.TrimSpace(root))
+
+	tree, err := git("rev-parse", "HEAD^{tree}")
+	if err != nil {
+		return errors.WithMessage(err, "getting tree of HEAD")
+	}
+	w.Write(tree)
+
+	changed, err := git("diff", "--name-only", "-z", "HEAD")
+	if err != nil {
+		return errors.WithMessage(err, "listing changed files")
+	}
+
+	for _, name := range strings.Split(string(changed), "\x00") {
+		if name == "" {
+			continue
+		}
+		fmt.Fprintf(w, "%s\x00", name)
+		if content, err := os.ReadFile(filepath.Join(top, name)); err == nil {
+			sum := sha256.Sum256(content)
+			w.Write(sum[:])
+		} else {
+			fmt.Fprint(w, "deleted")
+		}
+	}
+
+	return nil
+}
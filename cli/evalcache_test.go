@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// nixCalls counts the logged calls of the fake nix starting with the given arguments.
+func nixCalls(t *testing.T, calls, prefix string) int {
+	t.Helper()
+
+	content, err := os.ReadFile(calls)
+	if os.IsNotExist(err) {
+		return 0
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	n := 0
+	for _, call := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(call, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+// gitRepo creates a git repository with a committed flake.nix.
+func gitRepo(t *testing.T) string {
+	t.Helper()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+
+	dir := t.TempDir()
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(dir, ".gitconfig"))
+	t.Setenv("GIT_AUTHOR_NAME", "test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+
+	if err := os.WriteFile(filepath.Join(dir, "flake.nix"), []byte("{ }\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "flake.nix"},
+		{"commit", "-q", "-m", "init"},
+	} {
+		if out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %s\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	return dir
+}
+
+func TestEvalCacheOfGitFlake(t *testing.T) {
+	repo := gitRepo(t)
+	flake := repo + "#dag"
+	calls := fakeNix(t, map[string]string{flake: `{"build": []}`})
+	stateDir := t.TempDir()
+
+	evals := func() int { return nixCalls(t, calls, "eval --json "+flake) }
+	dag := func(refresh bool) {
+		t.Helper()
+		got, err := cachedDag(flake, stateDir, refresh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, map[string][]string{"build": {}}) {
+			t.Fatalf("unexpected DAG %v", got)
+		}
+	}
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(repo, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dag(false)
+	dag(false)
+	if n := evals(); n != 1 {
+		t.Fatalf("expected the second evaluation to be cached, nix evaluated %d times", n)
+	}
+
+	// nix doesn't see untracked files
+	write("notes.txt", "untracked")
+	dag(false)
+	if n := evals(); n != 1 {
+		t.Errorf("expected untracked files not to invalidate the cache, nix evaluated %d times", n)
+	}
+
+	write("flake.nix", "{ outputs = _: { }; }\n")
+	dag(false)
+	if n := evals(); n != 2 {
+		t.Errorf("expected a changed tracked file to invalidate the cache, nix evaluated %d times", n)
+	}
+
+	// changed again, it must not be mistaken for the first change
+	write("flake.nix", "{ outputs = _: { dag = { }; }; }\n")
+	dag(false)
+	if n := evals(); n != 3 {
+		t.Errorf("expected every content of a tracked file to have its own key, nix evaluated %d times", n)
+	}
+
+	// back to the committed content
+	write("flake.nix", "{ }\n")
+	dag(false)
+	if n := evals(); n != 3 {
+		t.Errorf("expected the committed content to be cached still, nix evaluated %d times", n)
+	}
+
+	dag(true)
+	if n := evals(); n != 4 {
+		t.Errorf("expected --refresh to evaluate again, nix evaluated %d times", n)
+	}
+}
+
+func TestFingerprintOfGitFlake(t *testing.T) {
+	repo := gitRepo(t)
+
+	fingerprint := func() string {
+		t.Helper()
+		key, err := flakeFingerprint(repo + "#dag")
+		if err != nil || key == "" {
+			t.Fatalf("no fingerprint: %v", err)
+		}
+		return key
+	}
+
+	clean := fingerprint()
+	if err := os.WriteFile(filepath.Join(repo, "flake.nix"), []byte("{ x = 1; }\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dirty := fingerprint()
+	if err := os.Remove(filepath.Join(repo, "flake.nix")); err != nil {
+		t.Fatal(err)
+	}
+	deleted := fingerprint()
+
+	if clean == dirty || dirty == deleted || clean == deleted {
+		t.Errorf("expected different keys for a clean, dirty and deleted file, got %s %s %s", clean, dirty, deleted)
+	}
+
+	other, err := flakeFingerprint(repo + "#task")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == deleted {
+		t.Error("expected different installables of the same flake to have different keys")
+	}
+}
+
+func TestEvalCacheOfRemoteFlake(t *testing.T) {
+	const flake = "github:input-output-hk/example#dag"
+	calls := fakeNix(t, map[string]string{flake: `{"lint": []}`})
+	stateDir := t.TempDir()
+	evals := func() int { return nixCalls(t, calls, "eval --json "+flake) }
+
+	// without a locked reference, nothing can be cached
+	for i := 0; i < 2; i++ {
+		if _, err := cachedDag(flake, stateDir, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := evals(); n != 2 {
+		t.Errorf("expected flakes without metadata to be evaluated every time, nix evaluated %d times", n)
+	}
+	if n := nixCalls(t, calls, "flake metadata --json github:input-output-hk/example"); n != 2 {
+		t.Errorf("expected the flake metadata to be asked for, got %d calls", n)
+	}
+
+	t.Setenv("FAKE_NIX_REV", "aaaa")
+	for i := 0; i < 2; i++ {
+		if _, err := cachedDag(flake, stateDir, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := evals(); n != 3 {
+		t.Errorf("expected a locked flake to be cached, nix evaluated %d times", n)
+	}
+
+	// the flake was updated
+	t.Setenv("FAKE_NIX_REV", "bbbb")
+	if _, err := cachedDag(flake, stateDir, false); err != nil {
+		t.Fatal(err)
+	}
+	if n := evals(); n != 4 {
+		t.Errorf("expected a new revision to invalidate the cache, nix evaluated %d times", n)
+	}
+}
+
+func TestPruneEvalCache(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	expected := []string{}
+	for i := 0; i < evalCacheSize+5; i++ {
+		name := fmt.Sprintf("%02d.json", i)
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("{}"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		// the higher the number, the more recent
+		modTime := now.Add(time.Duration(i-100) * time.Minute)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+		if i >= 5 {
+			expected = append(expected, name)
+		}
+	}
+
+	if err := pruneEvalCache(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := []string{}
+	for _, entry := range entries {
+		kept = append(kept, entry.Name())
+	}
+	sort.Strings(kept)
+
+	if !reflect.DeepEqual(kept, expected) {
+		t.Errorf("expected to keep the latest %d evaluations %v, got %v", evalCacheSize, expected, kept)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// event is published on the eventBus whenever a task changes.
+type event interface {
+	taskName() string
+}
+
+// stateEvent carries the new state of a task.
+type stateEvent struct {
+	task  string
+	state taskState
+}
+
+// outputEvent carries a chunk of output written by a task's command.
+type outputEvent struct {
+	task   string
+	stream string
+	data   []byte
+}
+
+func (e stateEvent) taskName() string  { return e.task }
+func (e outputEvent) taskName() string { return e.task }
+
+// eventBus distributes events of all tasks to any number of subscribers.
+// Publishing never blocks, every subscription buffers events until they are read.
+type eventBus struct {
+	mutex         sync.Mutex
+	subscriptions []*subscription
+	closed        bool
+}
+
+func (b *eventBus) subscribe() *subscription {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	s := &subscription{}
+	s.cond = sync.NewCond(&s.mutex)
+	if b.closed {
+		s.close()
+	}
+	b.subscriptions = append(b.subscriptions, s)
+	return s
+}
+
+func (b *eventBus) publish(e event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for _, s := range b.subscriptions {
+		s.push(e)
+	}
+}
+
+// close lets all subscribers know that no more events will follow.
+func (b *eventBus) close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.closed = true
+	for _, s := range b.subscriptions {
+		s.close()
+	}
+}
+
+type subscription struct {
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	queue  []event
+	closed bool
+}
+
+func (s *subscription) push(e event) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.queue = append(s.queue, e)
+	s.cond.Signal()
+}
+
+func (s *subscription) close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.closed = true
+	s.cond.Broadcast()
+}
+
+// next blocks until an event is available.
+// It returns false once the bus is closed and all events have been read.
+func (s *subscription) next() (event, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for len(s.queue) == 0 && !s.closed {
+		s.cond.Wait()
+	}
+	if len(s.queue) == 0 {
+		return nil, false
+	}
+
+	e := s.queue[0]
+	s.queue[0] = nil
+	s.queue = s.queue[1:]
+	return e, true
+}
+
+// outputWriter publishes everything written to it as outputEvents.
+type outputWriter struct {
+	task   *Task
+	stream string
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	if w.task.bus != nil {
+		w.task.bus.publish(outputEvent{
+			task:   w.task.name,
+			stream: w.stream,
+			data:   append([]byte{}, p...),
+		})
+	}
+	return len(p), nil
+}
+
+// reportJSON logs stage changes and every line of output of tasks as JSON.
+// Like the output itself, these are logged regardless of the log level.
+func reportJSON(log zerolog.Logger, s *subscription) {
+	log = log.With().Str("level", zerolog.LevelDebugValue).Logger()
+
+	type key struct{ task, stream string }
+	partial := map[key]*bytes.Buffer{}
+	stages := map[string]string{}
+
+	logLine := func(k key, line []byte) {
+		log.Log().Str("name", k.task).Str("std", k.stream).Msg(string(bytes.TrimRight(line, "\r\n")))
+	}
+
+	for {
+		e, ok := s.next()
+		if !ok {
+			break
+		}
+
+		switch e := e.(type) {
+		case stateEvent:
+			if stages[e.task] != e.state.stage {
+				stages[e.task] = e.state.stage
+				log.Log().Str("name", e.task).Str("stage", e.state.stage).Msg("stage")
+			}
+		case outputEvent:
+			k := key{e.task, e.stream}
+			buf, ok := partial[k]
+			if !ok {
+				buf = &bytes.Buffer{}
+				partial[k] = buf
+			}
+			buf.Write(e.data)
+			for {
+				line, err := buf.ReadBytes('\n')
+				if err != nil {
+					buf.Write(line)
+					break
+				}
+				logLine(k, line)
+			}
+		}
+	}
+
+	for k, buf := range partial {
+		if buf.Len() > 0 {
+			logLine(k, buf.Bytes())
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+// Run with -race: reporters subscribe and read tasks while the scheduler updates them.
+func TestEventsWhileRunning(t *testing.T) {
+	tree := newTestTree(t, Run{Task: "top"}, `{"version": 1, "tasks": {
+		"a": {"after": [], "bin": "/bin/echo"},
+		"b": {"after": [], "bin": "/bin/echo"},
+		"c": {"after": ["a"], "bin": "/bin/echo"},
+		"top": {"after": ["b", "c"], "bin": "/bin/echo"}
+	}}`)
+
+	var wg sync.WaitGroup
+	reporters := []*plainReporter{}
+	subscribe := func() {
+		r := newPlainReporter(tree, &bytes.Buffer{}, 0)
+		reporters = append(reporters, r)
+		s := tree.bus.subscribe()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.report(s)
+		}()
+	}
+
+	subscribe()
+
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			for _, task := range tree.tasks() {
+				state := task.snapshot()
+				_ = state.stageSpans()
+				_ = state.buildDuration() + state.runDuration()
+			}
+		}
+	}()
+
+	done := make(chan error, 1)
+	go func() { done <- tree.start() }()
+
+	// subscribing while tasks run must be safe as well
+	subscribe()
+
+	if err := <-done; err != nil {
+		t.Fatalf("running tasks: %s", err)
+	}
+	close(stop)
+	tree.bus.close()
+	wg.Wait()
+
+	// the late subscriber may have missed earlier events
+	if !reporters[0].idle() {
+		t.Errorf("expected the reporter to see all tasks finish")
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// exportedTask is what the export needs to know about a task from the task flake.
+type exportedTask struct {
+	DrvPath     string `json:"drvPath"`
+	Description string `json:"description"`
+}
+
+func (e Export) start() error {
+	switch e.Runtime {
+	case "nsjail", "podman", "unwrapped":
+	default:
+		return fmt.Errorf("Unknown runtime %q, expected one of nsjail,podman,unwrapped", e.Runtime)
+	}
+
+	dag, err := cachedDag(e.DagFlake, e.StateDir, e.Refresh)
+	if err != nil {
+		return err
+	}
+	graph := taskGraph(dag)
+
+	if err := graph.check(); err != nil {
+		return err
+	} else if err := graph.lookup(e.Task); err != nil {
+		return err
+	}
+
+	closure := graph.closure(e.Task, false)
+
+	fmt.Fprintf(os.Stderr, "Evaluating %s\n", plural(len(closure), "task"))
+	tasks, err := e.evalTasks(closure.names())
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Building %s\n", plural(len(closure), "task"))
+	bins, err := e.buildTasks(closure.names(), tasks)
+	if err != nil {
+		return err
+	}
+
+	spec := &RunSpec{Version: runSpecVersion, Tasks: map[string]*RunSpecTask{}}
+	for _, name := range closure.names() {
+		spec.Tasks[name] = &RunSpecTask{
+			After:       closure[name],
+			Bin:         bins[name],
+			Runtime:     e.Runtime,
+			Description: tasks[name].Description,
+		}
+	}
+
+	if e.To != "" {
+		fmt.Fprintf(os.Stderr, "Copying to %s\n", e.To)
+		if err := copyClosure(e.To, closure.names(), bins); err != nil {
+			return err
+		}
+	}
+
+	return writeRunSpec(e.Output, spec)
+}
+
+// evalTasks evaluates the derivations of the given tasks in one go.
+// The names are passed through the environment, as they can't always be quoted in an installable.
+func (e Export) evalTasks(names []string) (map[string]exportedTask, error) {
+	selected := map[string]bool{}
+	for _, name := range names {
+		selected[name] = true
+	}
+	selectedJSON, err := json.Marshal(selected)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(
+		"nix", "eval", "--json", "--impure", e.TaskFlake,
+		"--apply", fmt.Sprintf(
+			`f: builtins.mapAttrs (n: _: { drvPath = f.${n}.%s.run.drvPath; description = f.${n}.description or ""; }) (builtins.fromJSON (__getEnv "TULLIA_EXPORT_TASKS"))`,
+			e.Runtime,
+		),
+	)
+	cmd.Env = append(os.Environ(), "TULLIA_EXPORT_TASKS="+string(selectedJSON))
+	cmd.Stderr = os.Stderr
+
+	tasks := map[string]exportedTask{}
+	if output, err := cmd.Output(); err != nil {
+		return nil, errors.WithMessage(err, "evaluating tasks")
+	} else if err := json.Unmarshal(output, &tasks); err != nil {
+		return nil, errors.WithMessage(err, "parsing evaluated tasks")
+	}
+
+	for _, name := range names {
+		if tasks[name].DrvPath == "" {
+			return nil, fmt.Errorf("no derivation for task %q", name)
+		}
+	}
+
+	return tasks, nil
+}
+
+// buildTasks builds the runners of all tasks and returns the path of their executables.
+func (e Export) buildTasks(names []string, tasks map[string]exportedTask) (map[string]string, error) {
+	cmd := exec.Command("nix", "build", "--json", "--no-link")
+	for _, name := range names {
+		cmd.Args = append(cmd.Args, tasks[name].DrvPath)
+	}
+	cmd.Stderr = os.Stderr
+
+	results := []nixBuildResult{}
+	if output, err := cmd.Output(); err != nil {
+		return nil, errors.WithMessage(err, "building tasks")
+	} else if err := json.Unmarshal(output, &results); err != nil {
+		return nil, errors.WithMessage(err, "parsing build result")
+	}
+
+	outs := map[string]string{}
+	for _, result := range results {
+		outs[result.DrvPath] = result.Outputs.Out
+	}
+
+	bins := map[string]string{}
+	for _, name := range names {
+		out, ok := outs[tasks[name].DrvPath]
+		if !ok {
+			return nil, fmt.Errorf("no build result for task %q", name)
+		}
+		bins[name] = fmt.Sprintf("%s/bin/%s-%s", out, name, e.Runtime)
+	}
+
+	return bins, nil
+}
+
+// copyClosure copies the runners and everything they need to another store,
+// e.g. a binary cache in a directory with file:///path.
+func copyClosure(to string, names []string, bins map[string]string) error {
+	cmd := exec.Command("nix", "copy", "--to", to)
+	for _, name := range names {
+		// the executable is in the bin directory of the store path
+		cmd.Args = append(cmd.Args, filepath.Dir(filepath.Dir(bins[name])))
+	}
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return errors.WithMessagef(err, "copying to %s", to)
+	}
+	return nil
+}
+
+func writeRunSpec(path string, spec *RunSpec) error {
+	content, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return err
+	}
+	content = append(content, '\n')
+
+	if path == "-" {
+		_, err := os.Stdout.Write(content)
+		return err
+	}
+
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		return errors.WithMessage(err, "writing run spec")
+	}
+	fmt.Fprintf(os.Stderr, "Wrote run spec to %s, run it with --run-spec @%s\n", path, path)
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteRunSpecRoundTrip(t *testing.T) {
+	spec := &RunSpec{Version: runSpecVersion, Tasks: map[string]*RunSpecTask{
+		"build": {After: []string{"lint"}, Bin: "/nix/store/a-build/bin/build-nsjail", Runtime: "nsjail", Description: "builds it"},
+		"lint":  {After: []string{}, Bin: "/nix/store/b-lint/bin/lint-nsjail", Runtime: "nsjail"},
+	}}
+
+	path := filepath.Join(t.TempDir(), "spec.json")
+	if err := writeRunSpec(path, spec); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := parseRunSpec(content)
+	if err != nil {
+		t.Fatalf("written run spec doesn't parse: %s\n%s", err, content)
+	}
+	if parsed.Version != runSpecVersion {
+		t.Errorf("expected version %d, got %d", runSpecVersion, parsed.Version)
+	}
+	if !reflect.DeepEqual(parsed, spec) {
+		t.Errorf("expected %+v, got %+v", spec.Tasks, parsed.Tasks)
+	}
+}
+
+func TestExportRejectsBeforeNix(t *testing.T) {
+	calls := fakeNix(t, map[string]string{"default#dag": `{"build": ["lint"], "lint": []}`})
+	export := Export{
+		Task:      "build",
+		Output:    filepath.Join(t.TempDir(), "spec.json"),
+		DagFlake:  "default#dag",
+		TaskFlake: "default#task",
+		Runtime:   "nsjail",
+		StateDir:  t.TempDir(),
+	}
+
+	unknownRuntime := export
+	unknownRuntime.Runtime = "docker"
+	if err := unknownRuntime.start(); err == nil || !strings.Contains(err.Error(), `"docker"`) {
+		t.Errorf("expected an error about the runtime, got %v", err)
+	}
+	if _, err := os.Stat(calls); !os.IsNotExist(err) {
+		t.Errorf("nix was called for an unknown runtime")
+	}
+
+	unknownTask := export
+	unknownTask.Task = "biuld"
+	if err := unknownTask.start(); err == nil || !strings.Contains(err.Error(), `"biuld"`) {
+		t.Errorf("expected an error about the task, got %v", err)
+	}
+	// only the DAG is evaluated to know which tasks exist
+	content, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, call := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		if strings.Contains(call, "default#task") || strings.HasPrefix(call, "build") || strings.HasPrefix(call, "copy") {
+			t.Errorf("nix was called for an unknown task: nix %s", call)
+		}
+	}
+
+	if _, err := os.Stat(export.Output); !os.IsNotExist(err) {
+		t.Errorf("a run spec was written despite errors")
+	}
+}
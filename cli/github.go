@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// githubLog is the log of the job, shared by all tasks.
+var githubLog = &githubGroups{out: os.Stdout}
+
+// githubGroups streams the output of each task stage live into its own log group.
+// Groups can't be nested or interleaved, so only one stage at a time writes
+// to its group. Stages running at the same time buffer their output until
+// it's their turn, and then continue live.
+type githubGroups struct {
+	mutex sync.Mutex
+	out   io.Writer
+	queue []*githubGroup
+}
+
+type githubGroup struct {
+	groups *githubGroups
+	title  string
+	buffer bytes.Buffer
+	live   bool
+	ended  bool
+	// a workflow command written between groups instead of a group
+	command bool
+}
+
+// begin opens a group, which is shown right away unless another one is open.
+func (g *githubGroups) begin(title string) *githubGroup {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	group := &githubGroup{groups: g, title: title}
+	g.queue = append(g.queue, group)
+	g.advance()
+	return group
+}
+
+func (group *githubGroup) Write(p []byte) (int, error) {
+	g := group.groups
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if group.live {
+		return g.out.Write(p)
+	}
+	return group.buffer.Write(p)
+}
+
+// end closes the group once all groups before it are closed.
+func (group *githubGroup) end() {
+	g := group.groups
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	group.ended = true
+	g.advance()
+}
+
+// advance closes ended groups at the front of the queue and shows the next one.
+func (g *githubGroups) advance() {
+	for len(g.queue) > 0 {
+		head := g.queue[0]
+		if head.command {
+			_, _ = head.buffer.WriteTo(g.out)
+			g.queue = g.queue[1:]
+			continue
+		}
+		if !head.live {
+			fmt.Fprintf(g.out, "::group::%s\n", githubEscapeData(head.title))
+			_, _ = head.buffer.WriteTo(g.out)
+			head.live = true
+		}
+		if !head.ended {
+			return
+		}
+		fmt.Fprintln(g.out, "::endgroup::")
+		g.queue = g.queue[1:]
+	}
+}
+
+// command writes a workflow command, e.g. an annotation, right after the given group
+// is closed, or if it's closed already, once no other group is open.
+func (g *githubGroups) command(after *githubGroup, format string, args ...interface{}) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	command := &githubGroup{groups: g, command: true}
+	fmt.Fprintf(&command.buffer, format, args...)
+
+	// behind the open group, unless the given group is still waiting
+	at := min(1, len(g.queue))
+	for i, group := range g.queue {
+		if group == after {
+			at = i + 1
+		}
+	}
+	for at < len(g.queue) && g.queue[at].command {
+		at++
+	}
+
+	g.queue = append(g.queue[:at], append([]*githubGroup{command}, g.queue[at:]...)...)
+	g.advance()
+}
+
+func (s *Supervisor) startGitHub() error {
+	err := s.startCommon()
+
+	if path := os.Getenv("GITHUB_STEP_SUMMARY"); path != "" {
+		if summaryErr := s.writeGitHubSummary(path); summaryErr != nil {
+			s.config.log.Error().Err(summaryErr).Msg("writing job summary")
+		}
+	}
+
+	return err
+}
+
+func (s *Supervisor) writeGitHubSummary(path string) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return errors.WithMessage(err, "opening GITHUB_STEP_SUMMARY")
+	}
+	defer file.Close()
+
+	summary := s.tree.summary(s.config.Run.Task, nil)
+
+	out := &strings.Builder{}
+	fmt.Fprintf(out, "### tullia run `%s`\n\n", s.config.Run.Task)
+	fmt.Fprintln(out, "| Task | Status | Build | Run | CPU | Max RSS |")
+	fmt.Fprintln(out, "| --- | --- | --- | --- | --- | --- |")
+
+	failed := []taskSummary{}
+	for _, task := range summary.Tasks {
+		var icon string
+		switch task.Status {
+		case "done":
+			icon = "✔"
+		case "error":
+			icon = "✗"
+			failed = append(failed, task)
+		case "cancel":
+			icon = "⊘"
+		case "skip":
+			icon = "-"
+		default:
+			icon = "…"
+		}
+
+		build := summaryDuration(task.Build)
+		if task.Cached {
+			build += " (cached)"
+		}
+
+		fmt.Fprintf(out, "| `%s` | %s %s | %s | %s | %s | %s |\n",
+			task.Name, icon, task.Status, build,
+			summaryDuration(task.Run), summaryDuration(task.CPU), summaryBytes(task.MaxRSS),
+		)
+	}
+
+	fmt.Fprintf(out, "\nWall time: %s\n", summaryDuration(summary.WallTime))
+
+	for _, task := range failed {
+		fmt.Fprintf(out, "\n<details><summary><code>%s</code> failed</summary>\n\n```\n%s\n```\n\n</details>\n",
+			task.Name, task.Error)
+	}
+
+	_, err = file.WriteString(out.String() + "\n")
+	return err
+}
+
+func (t *Task) preExecGitHub() {
+	t.log.Debug().Stringer("cmd", t.cmd).Msg("start")
+	t.githubGroup = githubLog.begin(fmt.Sprintf("%s (%s)", t.name, t.snapshot().stage))
+	// every stream needs its own context, they would share the fields otherwise
+	log := func(stream string) zerolog.Logger {
+		return t.log.
+			Output(zerolog.ConsoleWriter{Out: t.githubGroup}).
+			With().
+			Str("level", zerolog.LevelInfoValue).
+			Timestamp().
+			Str("std", stream).
+			Logger()
+	}
+	if t.cmd.Stdout == nil {
+		t.cmd.Stdout = log("out")
+	}
+	if t.cmd.Stderr == nil {
+		t.cmd.Stderr = log("err")
+	}
+}
+
+// postExecGitHub closes the log group of the finished stage.
+// The group is kept to annotate a failure after it.
+func (t *Task) postExecGitHub(stage string, f func(), err error) error {
+	t.githubGroup.end()
+
+	return t.postExecCommon(stage, f, err)
+}
+
+// annotateGitHub emits an error annotation for the failed task after its log group.
+func (t *Task) annotateGitHub() {
+	githubLog.command(t.githubGroup, "::error title=%s::%s\n",
+		githubEscapeProperty(t.name), githubEscapeData(t.snapshot().err.Error()))
+}
+
+// See https://github.com/actions/toolkit/blob/main/packages/core/src/command.ts
+func githubEscapeData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+func githubEscapeProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestGitHubGroupsStreamOneAtATime(t *testing.T) {
+	out := &bytes.Buffer{}
+	groups := &githubGroups{out: out}
+
+	a := groups.begin("a (run)")
+	b := groups.begin("b (run)")
+
+	fmt.Fprintln(a, "a1")
+	fmt.Fprintln(b, "b1")
+	if expected := "::group::a (run)\na1\n"; out.String() != expected {
+		t.Fatalf("expected the first group to stream live, got %q", out.String())
+	}
+
+	// b finished first, but has to wait for a to close
+	b.end()
+	fmt.Fprintln(a, "a2")
+	a.end()
+
+	c := groups.begin("c (build)")
+	fmt.Fprintln(c, "c1")
+
+	expected := "::group::a (run)\na1\na2\n::endgroup::\n" +
+		"::group::b (run)\nb1\n::endgroup::\n" +
+		"::group::c (build)\nc1\n"
+	if out.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out.String())
+	}
+}
+
+func TestGitHubAnnotationsOutsideGroups(t *testing.T) {
+	out := &bytes.Buffer{}
+	groups := &githubGroups{out: out}
+
+	a := groups.begin("a (run)")
+	b := groups.begin("b (run)")
+	fmt.Fprintln(b, "b1")
+
+	// b failed while a is still open, its annotation follows its own group
+	b.end()
+	groups.command(b, "::error title=b::failed\n")
+
+	// c failed and was closed already, its annotation waits for a to close
+	fmt.Fprintln(a, "a1")
+	c := groups.begin("c (build)")
+	c.end()
+	groups.command(c, "::error title=c::failed\n")
+	fmt.Fprintln(a, "a2")
+
+	if expected := "::group::a (run)\na1\na2\n"; out.String() != expected {
+		t.Fatalf("expected annotations to wait for the open group, got %q", out.String())
+	}
+
+	a.end()
+
+	// nothing is open, so it's written right away
+	groups.command(nil, "::error title=d::failed\n")
+
+	expected := "::group::a (run)\na1\na2\n::endgroup::\n" +
+		"::group::b (run)\nb1\n::endgroup::\n" +
+		"::error title=b::failed\n" +
+		"::group::c (build)\n::endgroup::\n" +
+		"::error title=c::failed\n" +
+		"::error title=d::failed\n"
+	if out.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out.String())
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/plouc/textree"
+)
+
+// taskGraph maps every task to the tasks it runs after, as evaluated from the flake.
+type taskGraph map[string][]string
+
+// names returns all tasks ordered by name.
+func (g taskGraph) names() []string {
+	names := make([]string, 0, len(g))
+	for name := range g {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// dependents maps every task to the tasks that run after it, ordered by name.
+func (g taskGraph) dependents() map[string][]string {
+	dependents := map[string][]string{}
+	for _, name := range g.names() {
+		dependents[name] = []string{}
+	}
+	for _, name := range g.names() {
+		for _, after := range g[name] {
+			dependents[after] = append(dependents[after], name)
+		}
+	}
+	return dependents
+}
+
+// sinks are the tasks nothing else runs after.
+func (g taskGraph) sinks() []string {
+	dependents := g.dependents()
+	sinks := []string{}
+	for _, name := range g.names() {
+		if len(dependents[name]) == 0 {
+			sinks = append(sinks, name)
+		}
+	}
+	return sinks
+}
+
+// topologicalOrder returns the tasks in an order they can run in one after another.
+// Tasks that could run at the same time are ordered by name.
+func (g taskGraph) topologicalOrder() ([]string, error) {
+	pending := map[string]int{}
+	for name, afters := range g {
+		pending[name] = len(afters)
+	}
+	dependents := g.dependents()
+
+	ready := []string{}
+	for _, name := range g.names() {
+		if pending[name] == 0 {
+			ready = append(ready, name)
+		}
+	}
+
+	order := []string{}
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		name := ready[0]
+		ready = ready[1:]
+		order = append(order, name)
+
+		for _, dependent := range dependents[name] {
+			pending[dependent]--
+			if pending[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	if len(order) != len(g) {
+		return nil, fmt.Errorf("the tasks contain a cycle")
+	}
+
+	return order, nil
+}
+
+// closure returns the given task and all tasks it transitively runs after,
+// or, if reverse is set, all tasks that transitively run after it.
+func (g taskGraph) closure(name string, reverse bool) taskGraph {
+	edges := map[string][]string(g)
+	if reverse {
+		edges = g.dependents()
+	}
+
+	sub := taskGraph{}
+	var visit func(string)
+	visit = func(name string) {
+		if _, ok := sub[name]; ok {
+			return
+		}
+		sub[name] = []string{}
+		for _, next := range edges[name] {
+			visit(next)
+		}
+	}
+	visit(name)
+
+	// keep only the edges between the selected tasks
+	for name := range sub {
+		for _, after := range g[name] {
+			if _, ok := sub[after]; ok {
+				sub[name] = append(sub[name], after)
+			}
+		}
+		sort.Strings(sub[name])
+	}
+
+	return sub
+}
+
+// tree renders everything the given task runs after, or with reverse set,
+// everything that runs after it. A task that shows up more than once is only
+// expanded the first time, later occurrences are marked with (*).
+// Tasks are labeled with their runtime if known.
+func (g taskGraph) tree(name string, reverse bool, runtimes map[string]string) *textree.Node {
+	edges := map[string][]string(g)
+	if reverse {
+		edges = g.dependents()
+	}
+
+	// textree lays out nodes as they are appended, so the tree is built top down
+	expanded := map[string]bool{name: true}
+	var expand func(*textree.Node, string)
+	expand = func(parent *textree.Node, name string) {
+		next := append([]string{}, edges[name]...)
+		sort.Strings(next)
+		for _, child := range next {
+			if expanded[child] {
+				parent.Append(textree.NewNode(runtimeLabel(child, runtimes) + " (*)"))
+				continue
+			}
+			expanded[child] = true
+			node := textree.NewNode(runtimeLabel(child, runtimes))
+			parent.Append(node)
+			expand(node, child)
+		}
+	}
+
+	root := textree.NewNode(runtimeLabel(name, runtimes))
+	expand(root, name)
+	return root
+}
+
+// runtimeLabel appends the runtime of the task to its name, e.g. "build [nsjail]".
+func runtimeLabel(name string, runtimes map[string]string) string {
+	if runtime, ok := runtimes[name]; ok {
+		return fmt.Sprintf("%s [%s]", name, runtime)
+	}
+	return name
+}
+
+// writeDot renders the graph in the DOT language of Graphviz.
+// Edges point from a task to those that run after it.
+func (g taskGraph) writeDot(w io.Writer, runtimes map[string]string) {
+	sinks := map[string]bool{}
+	for _, sink := range g.sinks() {
+		sinks[sink] = true
+	}
+
+	fmt.Fprintln(w, "digraph tullia {")
+	fmt.Fprintln(w, "  rankdir=LR;")
+	fmt.Fprintln(w, "  node [shape=box];")
+	for _, name := range g.names() {
+		attrs := []string{
+			fmt.Sprintf("label=%s", dotQuote(name)),
+		}
+		if runtime, ok := runtimes[name]; ok {
+			attrs = append(attrs, fmt.Sprintf("runtime=%s", dotQuote(runtime)))
+		}
+		attrs = append(attrs, fmt.Sprintf("sink=%t", sinks[name]))
+		if sinks[name] {
+			attrs = append(attrs, "peripheries=2")
+		}
+		fmt.Fprintf(w, "  %s [%s];\n", dotQuote(name), strings.Join(attrs, ", "))
+	}
+	for _, name := range g.names() {
+		for _, after := range g[name] {
+			fmt.Fprintf(w, "  %s -> %s;\n", dotQuote(after), dotQuote(name))
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// writeMermaid renders the graph as a Mermaid flowchart, e.g. for markdown.
+// Task names are used as labels only, as they may contain characters
+// that aren't allowed in node IDs.
+func (g taskGraph) writeMermaid(w io.Writer) {
+	ids := map[string]string{}
+	for i, name := range g.names() {
+		ids[name] = fmt.Sprintf("t%d", i)
+	}
+
+	fmt.Fprintln(w, "flowchart LR")
+	for _, name := range g.names() {
+		fmt.Fprintf(w, "  %s[\"%s\"]\n", ids[name], strings.ReplaceAll(name, `"`, "#quot;"))
+	}
+	for _, name := range g.names() {
+		for _, after := range g[name] {
+			fmt.Fprintf(w, "  %s --> %s\n", ids[after], ids[name])
+		}
+	}
+}
+
+type graphJSON struct {
+	Tasks        []string            `json:"tasks"`
+	Dependencies map[string][]string `json:"dependencies"`
+	Dependents   map[string][]string `json:"dependents"`
+	Sinks        []string            `json:"sinks"`
+	Order        []string            `json:"order"`
+	Runtimes     map[string]string   `json:"runtimes"`
+}
+
+// writeJSON outputs the adjacency in both directions, the topological order,
+// and the runtime of every task.
+func (g taskGraph) writeJSON(w io.Writer, runtimes map[string]string) error {
+	order, err := g.topologicalOrder()
+	if err != nil {
+		return err
+	}
+
+	dependencies := map[string][]string{}
+	for name, afters := range g {
+		sorted := append([]string{}, afters...)
+		sort.Strings(sorted)
+		dependencies[name] = sorted
+	}
+
+	selected := map[string]string{}
+	for name := range g {
+		if runtime, ok := runtimes[name]; ok {
+			selected[name] = runtime
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(graphJSON{
+		Tasks:        g.names(),
+		Dependencies: dependencies,
+		Dependents:   g.dependents(),
+		Sinks:        g.sinks(),
+		Order:        order,
+		Runtimes:     selected,
+	})
+}
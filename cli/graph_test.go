@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/plouc/textree"
+)
+
+func TestWriteDot(t *testing.T) {
+	out := &bytes.Buffer{}
+	taskGraph{"a": {}, "b \"quoted\"": {"a"}}.writeDot(out, map[string]string{"a": "podman", "b \"quoted\"": "nsjail"})
+
+	expected := `digraph tullia {
+  rankdir=LR;
+  node [shape=box];
+  "a" [label="a", runtime="podman", sink=false];
+  "b \"quoted\"" [label="b \"quoted\"", runtime="nsjail", sink=true, peripheries=2];
+  "a" -> "b \"quoted\"";
+}
+`
+	if out.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out.String())
+	}
+}
+
+func TestWriteJSONRuntimes(t *testing.T) {
+	out := &bytes.Buffer{}
+	graph := taskGraph{"a": {}, "b": {"a"}}
+	if err := graph.writeJSON(out, map[string]string{"a": "unwrapped", "b": "nsjail", "other": "podman"}); err != nil {
+		t.Fatal(err)
+	}
+
+	result := graphJSON{}
+	if err := json.Unmarshal(out.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]string{"a": "unwrapped", "b": "nsjail"}; !reflect.DeepEqual(result.Runtimes, expected) {
+		t.Errorf("expected runtimes %v, got %v", expected, result.Runtimes)
+	}
+}
+
+func TestTreeRuntimes(t *testing.T) {
+	out := &bytes.Buffer{}
+	graph := taskGraph{"a": {}, "b": {"a"}, "c": {"a", "b"}}
+	graph.tree("c", false, map[string]string{"a": "podman", "b": "nsjail", "c": "nsjail"}).Render(out, textree.NewRenderOptions())
+
+	for _, label := range []string{"c [nsjail]", "a [podman]", "b [nsjail]", "a [podman] (*)"} {
+		if !strings.Contains(out.String(), label) {
+			t.Errorf("expected %q in\n%s", label, out.String())
+		}
+	}
+}
+
+// diamond is a graph where d runs after b and c, which both run after a,
+// and e is unrelated to all of them.
+var diamond = taskGraph{"a": {}, "b": {"a"}, "c": {"a"}, "d": {"b", "c"}, "e": {}}
+
+func TestClosure(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		reverse  bool
+		expected taskGraph
+	}{
+		{"d", false, taskGraph{"a": {}, "b": {"a"}, "c": {"a"}, "d": {"b", "c"}}},
+		{"b", false, taskGraph{"a": {}, "b": {"a"}}},
+		{"a", false, taskGraph{"a": {}}},
+		{"e", false, taskGraph{"e": {}}},
+		{"a", true, taskGraph{"a": {}, "b": {"a"}, "c": {"a"}, "d": {"b", "c"}}},
+		{"c", true, taskGraph{"c": {}, "d": {"c"}}},
+		{"d", true, taskGraph{"d": {}}},
+	} {
+		if got := diamond.closure(tc.name, tc.reverse); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("expected the closure of %s (reverse %t) to be %v, got %v", tc.name, tc.reverse, tc.expected, got)
+		}
+	}
+}
+
+func TestTree(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		reverse  bool
+		expected string
+	}{
+		{"d", false, "d\n├─┬ b\n│ └── a\n└─┬ c\n  └── a (*)\n"},
+		{"a", true, "a\n├─┬ b\n│ └── d\n└─┬ c\n  └── d (*)\n"},
+		{"e", false, "e\n"},
+	} {
+		out := &bytes.Buffer{}
+		o := textree.NewRenderOptions()
+		o.Compact()
+		diamond.tree(tc.name, tc.reverse, nil).Render(out, o)
+		if got := strings.ReplaceAll(out.String(), "┌ ", ""); got != tc.expected {
+			t.Errorf("expected the tree of %s (reverse %t) to be\n%s\ngot\n%s", tc.name, tc.reverse, tc.expected, out.String())
+		}
+	}
+}
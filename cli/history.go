@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// historyEntry is the outcome of a run as appended to `<state-dir>/history.jsonl`.
+type historyEntry struct {
+	Run         string                 `json:"run,omitempty"`
+	Target      string                 `json:"target"`
+	Started     time.Time              `json:"started"`
+	WallSeconds float64                `json:"wallSeconds"`
+	Success     bool                   `json:"success"`
+	Tasks       map[string]historyTask `json:"tasks"`
+}
+
+type historyTask struct {
+	Stage        string  `json:"stage"`
+	BuildSeconds float64 `json:"buildSeconds,omitempty"`
+	RunSeconds   float64 `json:"runSeconds,omitempty"`
+}
+
+func historyPath(stateDir string) string {
+	return filepath.Join(stateDir, "history.jsonl")
+}
+
+func (t *Tree) historyEntry(runID string, err error) historyEntry {
+	entry := historyEntry{
+		Run:         runID,
+		Target:      t.config.Run.Task,
+		Started:     t.started,
+		WallSeconds: spanDuration(t.started, t.finished).Seconds(),
+		Success:     err == nil,
+		Tasks:       map[string]historyTask{},
+	}
+
+	for _, task := range t.tasks() {
+		state := task.snapshot()
+		entry.Tasks[task.name] = historyTask{
+			Stage:        state.stage,
+			BuildSeconds: state.buildDuration().Seconds(),
+			RunSeconds:   state.runDuration().Seconds(),
+		}
+	}
+
+	return entry
+}
+
+func appendHistory(stateDir string, entry historyEntry) error {
+	if err := os.MkdirAll(stateDir, 0o755); err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(historyPath(stateDir), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// a single write, so concurrent runs don't mix up their lines
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// loadHistory reads all recorded runs, from oldest to newest.
+func loadHistory(stateDir string) ([]historyEntry, error) {
+	file, err := os.Open(historyPath(stateDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := []historyEntry{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		entry := historyEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// skip lines truncated by an interrupted write
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+// taskStats summarizes the recorded runs of a task.
+type taskStats struct {
+	Task        string  `json:"task"`
+	Runs        int     `json:"runs"`
+	Failures    int     `json:"failures"`
+	FailureRate float64 `json:"failureRate"`
+	MedianBuild float64 `json:"medianBuildSeconds"`
+	MedianRun   float64 `json:"medianRunSeconds"`
+	P95Run      float64 `json:"p95RunSeconds"`
+	// Trend is the relative change of the median run time
+	// of the newer half of runs compared to the older half.
+	Trend float64 `json:"trend"`
+}
+
+// historyStats computes statistics for every task over its last n runs.
+// n <= 0 considers all runs.
+func historyStats(entries []historyEntry, n int) map[string]*taskStats {
+	type sample struct {
+		stage      string
+		build, run float64
+	}
+
+	samples := map[string][]sample{}
+	for _, entry := range entries {
+		for name, task := range entry.Tasks {
+			samples[name] = append(samples[name], sample{task.Stage, task.BuildSeconds, task.RunSeconds})
+		}
+	}
+
+	stats := map[string]*taskStats{}
+	for name, all := range samples {
+		if n > 0 && len(all) > n {
+			all = all[len(all)-n:]
+		}
+
+		s := &taskStats{Task: name}
+		builds, runs := []float64{}, []float64{}
+		for _, sample := range all {
+			switch sample.stage {
+			case "done":
+				s.Runs++
+				runs = append(runs, sample.run)
+				if sample.build > 0 {
+					builds = append(builds, sample.build)
+				}
+			case "error":
+				s.Runs++
+				s.Failures++
+			}
+		}
+		if s.Runs == 0 {
+			continue
+		}
+
+		s.FailureRate = float64(s.Failures) / float64(s.Runs)
+		s.MedianBuild = percentile(builds, 50)
+		s.MedianRun = percentile(runs, 50)
+		s.P95Run = percentile(runs, 95)
+		if half := len(runs) / 2; half > 0 {
+			if older := percentile(runs[:half], 50); older > 0 {
+				s.Trend = percentile(runs[len(runs)-half:], 50)/older - 1
+			}
+		}
+
+		stats[name] = s
+	}
+
+	return stats
+}
+
+// percentile uses the nearest-rank method. It does not modify values.
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func (h History) start() error {
+	entries, err := loadHistory(h.StateDir)
+	if err != nil {
+		return errors.WithMessage(err, "reading history")
+	}
+
+	stats := historyStats(entries, h.Last)
+
+	names := []string{}
+	for name := range stats {
+		if h.Task == "" || h.Task == name {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	if h.JSON {
+		out := []*taskStats{}
+		for _, name := range names {
+			out = append(out, stats[name])
+		}
+		return json.NewEncoder(os.Stdout).Encode(out)
+	}
+
+	if len(names) == 0 {
+		fmt.Fprintln(os.Stderr, "No history recorded yet")
+		return nil
+	}
+
+	return writeHistoryTable(os.Stdout, names, stats)
+}
+
+func writeHistoryTable(w io.Writer, names []string, stats map[string]*taskStats) error {
+	nameLen := len("TASK")
+	for _, name := range names {
+		if len(name) > nameLen {
+			nameLen = len(name)
+		}
+	}
+
+	seconds := func(s float64) string {
+		return time.Duration(s * float64(time.Second)).Round(time.Millisecond).String()
+	}
+
+	fmt.Fprintf(w, "%-*s  %5s  %8s  %10s  %10s  %10s  %s\n",
+		nameLen, "TASK", "RUNS", "FAILURES", "BUILD p50", "RUN p50", "RUN p95", "TREND")
+	for _, name := range names {
+		s := stats[name]
+
+		trend := "→"
+		switch {
+		case s.Trend >= 0.1:
+			trend = fmt.Sprintf("↑ %+.0f%%", s.Trend*100)
+		case s.Trend <= -0.1:
+			trend = fmt.Sprintf("↓ %+.0f%%", s.Trend*100)
+		}
+
+		fmt.Fprintf(w, "%-*s  %5d  %7.0f%%  %10s  %10s  %10s  %s\n",
+			nameLen, name, s.Runs, s.FailureRate*100,
+			seconds(s.MedianBuild), seconds(s.MedianRun), seconds(s.P95Run), trend)
+	}
+
+	return nil
+}
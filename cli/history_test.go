@@ -0,0 +1,107 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestPercentile(t *testing.T) {
+	for _, tc := range []struct {
+		values   []float64
+		p        float64
+		expected float64
+	}{
+		{nil, 50, 0},
+		{[]float64{}, 95, 0},
+		{[]float64{3}, 0, 3},
+		{[]float64{3}, 50, 3},
+		{[]float64{3}, 95, 3},
+		{[]float64{3}, 100, 3},
+		{[]float64{5, 1}, 0, 1},
+		{[]float64{5, 1}, 50, 1},
+		{[]float64{5, 1}, 51, 5},
+		{[]float64{5, 1}, 95, 5},
+		{[]float64{5, 1}, 100, 5},
+		{[]float64{15, 20, 35, 40, 50}, 30, 20},
+		{[]float64{15, 20, 35, 40, 50}, 40, 20},
+		{[]float64{15, 20, 35, 40, 50}, 50, 35},
+		{[]float64{15, 20, 35, 40, 50}, 100, 50},
+	} {
+		values := append([]float64(nil), tc.values...)
+		if got := percentile(tc.values, tc.p); got != tc.expected {
+			t.Errorf("expected the %vth percentile of %v to be %v, got %v", tc.p, tc.values, tc.expected, got)
+		}
+		if len(values) > 0 && !reflect.DeepEqual(values, tc.values) {
+			t.Errorf("percentile modified its input to %v", tc.values)
+		}
+	}
+}
+
+func TestHistoryStats(t *testing.T) {
+	run := func(stage string, build, run float64) historyEntry {
+		return historyEntry{Tasks: map[string]historyTask{
+			"a": {Stage: stage, BuildSeconds: build, RunSeconds: run},
+		}}
+	}
+
+	for _, tc := range []struct {
+		name     string
+		entries  []historyEntry
+		n        int
+		expected *taskStats
+	}{
+		{
+			name:     "no runs",
+			entries:  []historyEntry{run("cancel", 0, 0), run("skip", 0, 0)},
+			expected: nil,
+		},
+		{
+			name:     "single run",
+			entries:  []historyEntry{run("done", 2, 10)},
+			expected: &taskStats{Task: "a", Runs: 1, MedianBuild: 2, MedianRun: 10, P95Run: 10},
+		},
+		{
+			name:     "slower",
+			entries:  []historyEntry{run("done", 0, 10), run("done", 0, 20)},
+			expected: &taskStats{Task: "a", Runs: 2, MedianRun: 10, P95Run: 20, Trend: 1},
+		},
+		{
+			name: "faster with failures",
+			entries: []historyEntry{
+				run("done", 1, 40), run("error", 1, 0), run("done", 1, 30),
+				run("done", 1, 20), run("error", 1, 0), run("done", 1, 20),
+			},
+			expected: &taskStats{Task: "a", Runs: 6, Failures: 2, FailureRate: 2.0 / 6, MedianBuild: 1, MedianRun: 20, P95Run: 40, Trend: -1.0 / 3},
+		},
+		{
+			name: "last runs only",
+			entries: []historyEntry{
+				run("error", 0, 0), run("error", 0, 0), run("done", 0, 10), run("done", 0, 10),
+			},
+			n:        2,
+			expected: &taskStats{Task: "a", Runs: 2, MedianRun: 10, P95Run: 10},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := historyStats(tc.entries, tc.n)["a"]
+			if tc.expected == nil || got == nil {
+				if got != tc.expected {
+					t.Errorf("expected %+v, got %+v", tc.expected, got)
+				}
+				return
+			}
+
+			// compare rates without rounding errors
+			if math.Abs(got.Trend-tc.expected.Trend) < 1e-9 {
+				got.Trend = tc.expected.Trend
+			}
+			if math.Abs(got.FailureRate-tc.expected.FailureRate) < 1e-9 {
+				got.FailureRate = tc.expected.FailureRate
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, got)
+			}
+		})
+	}
+}
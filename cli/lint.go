@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// lintFinding is a suggestion to tidy up the declared tasks.
+type lintFinding struct {
+	Kind    string   `json:"kind"`
+	Task    string   `json:"task"`
+	After   string   `json:"after,omitempty"`
+	Via     []string `json:"via,omitempty"`
+	Message string   `json:"message"`
+}
+
+type lintResult struct {
+	Findings  []lintFinding `json:"findings"`
+	Reduction taskGraph     `json:"reduction"`
+}
+
+// transitiveReduction returns the graph without the edges implied by others.
+// The graph must not contain cycles.
+func (g taskGraph) transitiveReduction() taskGraph {
+	redundant := map[[2]string]bool{}
+	for _, edge := range g.redundantEdges() {
+		redundant[[2]string{edge.task, edge.after}] = true
+	}
+
+	reduced := taskGraph{}
+	for _, name := range g.names() {
+		reduced[name] = []string{}
+		for _, after := range g[name] {
+			if !redundant[[2]string{name, after}] {
+				reduced[name] = append(reduced[name], after)
+			}
+		}
+	}
+	return reduced
+}
+
+// lint finds edges that can be removed and tasks nothing runs after
+// without a description of what they are for.
+func (g taskGraph) lint(descriptions map[string]string) lintResult {
+	result := lintResult{Findings: []lintFinding{}, Reduction: g.transitiveReduction()}
+
+	for _, edge := range g.redundantEdges() {
+		result.Findings = append(result.Findings, lintFinding{
+			Kind:    "redundant-edge",
+			Task:    edge.task,
+			After:   edge.after,
+			Via:     edge.via,
+			Message: fmt.Sprintf("%s doesn't need to run after %s, it already does through %s", edge.task, edge.after, strings.Join(edge.via, " → ")),
+		})
+	}
+
+	for _, sink := range g.sinks() {
+		if strings.TrimSpace(descriptions[sink]) == "" {
+			result.Findings = append(result.Findings, lintFinding{
+				Kind:    "undocumented",
+				Task:    sink,
+				Message: fmt.Sprintf("%s has no description and nothing runs after it", sink),
+			})
+		}
+	}
+
+	return result
+}
+
+// parseDescriptions evaluates the description of every task.
+func parseDescriptions(taskFlake string) (map[string]string, error) {
+	cmd := exec.Command("nix", "eval", "--json", taskFlake, "--apply", "builtins.mapAttrs (_: task: task.description or \"\")")
+	cmd.Stderr = os.Stderr
+
+	descriptions := map[string]string{}
+	if output, err := cmd.Output(); err != nil {
+		return nil, errors.WithMessage(err, "running eval")
+	} else if err := json.Unmarshal(output, &descriptions); err != nil {
+		return nil, errors.WithMessage(err, "parsing eval result")
+	}
+
+	return descriptions, nil
+}
+
+// parseRuntimes evaluates the runtime of every task.
+func parseRuntimes(taskFlake string) (map[string]string, error) {
+	cmd := exec.Command("nix", "eval", "--json", taskFlake, "--apply", "builtins.mapAttrs (_: task: task.runtime or \"nsjail\")")
+	cmd.Stderr = os.Stderr
+
+	runtimes := map[string]string{}
+	if output, err := cmd.Output(); err != nil {
+		return nil, errors.WithMessage(err, "running eval")
+	} else if err := json.Unmarshal(output, &runtimes); err != nil {
+		return nil, errors.WithMessage(err, "parsing eval result")
+	}
+
+	return runtimes, nil
+}
+
+func (l GraphLint) start() error {
+	dag, err := cachedDag(l.DagFlake, l.StateDir, l.Refresh)
+	if err != nil {
+		return err
+	}
+	graph := taskGraph(dag)
+
+	if err := graph.check(); err != nil {
+		return err
+	}
+
+	descriptions, err := parseDescriptions(l.TaskFlake)
+	if err != nil {
+		return err
+	}
+
+	result := graph.lint(descriptions)
+
+	if l.JSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			return err
+		}
+	} else {
+		for _, finding := range result.Findings {
+			fmt.Fprintf(os.Stdout, "%s: %s\n", finding.Kind, finding.Message)
+		}
+	}
+
+	if len(result.Findings) > 0 {
+		return fmt.Errorf("found %s", plural(len(result.Findings), "finding"))
+	}
+
+	return nil
+}
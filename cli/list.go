@@ -23,10 +23,49 @@ func (l List) start() error {
 		os.Exit(1)
 	}
 
-	dag, err := parseDag(l.DagFlake)
+	switch l.Format {
+	case "tree", "dot", "mermaid", "json":
+	default:
+		return fmt.Errorf("Unknown format: %q", l.Format)
+	}
+
+	dag, err := cachedDag(l.DagFlake, l.StateDir, l.Refresh)
 	if err != nil {
 		return err
 	}
+	graph := taskGraph(dag)
+
+	runtimes := map[string]string{}
+	if l.Format != "mermaid" {
+		if runtimes, err = cachedRuntimes(l.TaskFlake, l.StateDir, l.Refresh); err != nil {
+			return err
+		}
+	}
+
+	if l.Task != "" {
+		if err := graph.lookup(l.Task); err != nil {
+			return err
+		}
+		graph = graph.closure(l.Task, l.Reverse)
+	} else if l.Reverse {
+		return fmt.Errorf("--reverse requires a task")
+	}
+
+	switch l.Format {
+	case "dot":
+		graph.writeDot(os.Stdout, runtimes)
+		return nil
+	case "mermaid":
+		graph.writeMermaid(os.Stdout)
+		return nil
+	case "json":
+		return graph.writeJSON(os.Stdout, runtimes)
+	}
+
+	if l.Task != "" {
+		graph.tree(l.Task, l.Reverse, runtimes).Render(os.Stdout, o)
+		return nil
+	}
 
 	keys := []string{}
 	for k, v := range dag {
@@ -38,11 +77,11 @@ func (l List) start() error {
 	root := textree.NewNode("tullia run")
 
 	for _, key := range keys {
-		child := textree.NewNode(key)
+		child := textree.NewNode(runtimeLabel(key, runtimes))
 		root.Append(child)
 
 		for _, value := range dag[key] {
-			child.Append(textree.NewNode(value))
+			child.Append(textree.NewNode(runtimeLabel(value, runtimes)))
 		}
 	}
 
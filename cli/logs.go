@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func (l Logs) start() error {
+	runs, err := loadRuns(l.StateDir)
+	if err != nil {
+		return err
+	}
+
+	if l.List {
+		return listRuns(os.Stdout, runs)
+	}
+
+	run, err := findRun(runs, l.Run)
+	if err != nil {
+		return err
+	}
+
+	if l.Grep != "" {
+		re, err := regexp.Compile(l.Grep)
+		if err != nil {
+			return errors.WithMessage(err, "parsing --grep")
+		}
+		return run.grep(os.Stdout, re, l.Task)
+	}
+
+	taskName := l.Task
+	if taskName == "" {
+		if taskName, err = run.defaultTask(); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Showing log of %q in run %s\n", taskName, run.ID)
+	}
+
+	return run.show(os.Stdout, taskName, l.Follow)
+}
+
+// loadRuns reads the metadata of all persisted runs, ordered from oldest to newest.
+func loadRuns(stateDir string) ([]*runLog, error) {
+	dir := runsDir(stateDir)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.WithMessage(err, "reading runs")
+	}
+
+	runs := []*runLog{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if run, err := loadRun(filepath.Join(dir, entry.Name())); err == nil {
+			runs = append(runs, run)
+		}
+	}
+
+	sort.Slice(runs, func(i, j int) bool { return runs[i].ID < runs[j].ID })
+
+	return runs, nil
+}
+
+func loadRun(dir string) (*runLog, error) {
+	content, err := os.ReadFile(filepath.Join(dir, "run.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	run := &runLog{dir: dir}
+	if err := json.Unmarshal(content, run); err != nil {
+		return nil, errors.WithMessagef(err, "parsing %s", dir)
+	}
+	return run, nil
+}
+
+func listRuns(w io.Writer, runs []*runLog) error {
+	if len(runs) == 0 {
+		fmt.Fprintln(os.Stderr, "No runs found")
+		return nil
+	}
+
+	idLen, targetLen := len("ID"), len("TARGET")
+	for _, run := range runs {
+		if len(run.ID) > idLen {
+			idLen = len(run.ID)
+		}
+		if len(run.Target) > targetLen {
+			targetLen = len(run.Target)
+		}
+	}
+
+	fmt.Fprintf(w, "%-*s  %-*s  %-19s  %-9s  %s\n", idLen, "ID", targetLen, "TARGET", "STARTED", "DURATION", "OUTCOME")
+	for _, run := range runs {
+		duration := "-"
+		if !run.Finished.IsZero() {
+			duration = run.Finished.Sub(run.Started).Round(time.Second).String()
+		}
+		fmt.Fprintf(w, "%-*s  %-*s  %-19s  %-9s  %s\n",
+			idLen, run.ID,
+			targetLen, run.Target,
+			run.Started.Local().Format("2006-01-02 15:04:05"),
+			duration,
+			run.Outcome,
+		)
+	}
+
+	return nil
+}
+
+// findRun returns the run with the given ID or unique ID prefix,
+// or the latest run if id is empty.
+func findRun(runs []*runLog, id string) (*runLog, error) {
+	if len(runs) == 0 {
+		return nil, errors.New("No runs found")
+	}
+	if id == "" {
+		return runs[len(runs)-1], nil
+	}
+
+	matches := []*runLog{}
+	for _, run := range runs {
+		if run.ID == id {
+			return run, nil
+		} else if strings.HasPrefix(run.ID, id) {
+			matches = append(matches, run)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("No run with ID %q", id)
+	case 1:
+		return matches[0], nil
+	default:
+		return nil, fmt.Errorf("Run ID %q is ambiguous", id)
+	}
+}
+
+// logFiles returns the names of all tasks with a log file in this run.
+func (r *runLog) logFiles() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(r.dir, "*.log"))
+	if err != nil {
+		return nil, err
+	}
+
+	taskNames := []string{}
+	for _, path := range paths {
+		if taskName, err := logTaskName(filepath.Base(path)); err == nil {
+			taskNames = append(taskNames, taskName)
+		}
+	}
+	sort.Strings(taskNames)
+	return taskNames, nil
+}
+
+// defaultTask returns the task that failed last,
+// or the task that wrote to its log last if none failed.
+func (r *runLog) defaultTask() (string, error) {
+	failed := map[string]bool{}
+	for taskName, task := range r.Tasks {
+		if task.Error != "" {
+			failed[taskName] = true
+		}
+	}
+
+	taskNames, err := r.logFiles()
+	if err != nil {
+		return "", err
+	}
+
+	var latest string
+	var latestTime time.Time
+	var latestFailed bool
+	for _, taskName := range taskNames {
+		info, err := os.Stat(r.taskPath(taskName))
+		if err != nil {
+			continue
+		}
+		isFailed := failed[taskName]
+		if (isFailed && !latestFailed) || (isFailed == latestFailed && info.ModTime().After(latestTime)) {
+			latest, latestTime, latestFailed = taskName, info.ModTime(), isFailed
+		}
+	}
+
+	if latest == "" {
+		return "", fmt.Errorf("Run %s has no logs", r.ID)
+	}
+	return latest, nil
+}
+
+// show writes the log of a task to w.
+// With follow, it keeps waiting for new output until the run is finished.
+func (r *runLog) show(w io.Writer, taskName string, follow bool) error {
+	file, err := os.Open(r.taskPath(taskName))
+	if os.IsNotExist(err) && follow {
+		// the task may not have started yet
+		for os.IsNotExist(err) && r.running() {
+			time.Sleep(250 * time.Millisecond)
+			file, err = os.Open(r.taskPath(taskName))
+		}
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			available, _ := r.logFiles()
+			return fmt.Errorf("No log for %q in run %s. Available: %s", taskName, r.ID, strings.Join(available, " "))
+		}
+		return err
+	}
+	defer file.Close()
+
+	for {
+		if _, err := io.Copy(w, file); err != nil {
+			return err
+		}
+		if !follow {
+			return nil
+		}
+		if !r.running() {
+			// copy whatever was written since
+			if _, err := io.Copy(w, file); err != nil {
+				return err
+			}
+			if run, err := loadRun(r.dir); err == nil && run.Outcome == runOutcomeRunning {
+				fmt.Fprintf(os.Stderr, "Run %s stopped without finishing\n", r.ID)
+			}
+			return nil
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// followStaleAfter is how long a run whose process can't be checked
+// may go without writing logs before it is considered dead.
+const followStaleAfter = 10 * time.Minute
+
+// running reloads the run's metadata and tells whether it is still in progress.
+// Runs that crashed or were killed never record that they finished,
+// so their process must still exist, or their logs still be written to.
+func (r *runLog) running() bool {
+	run, err := loadRun(r.dir)
+	if err != nil || run.Outcome != runOutcomeRunning {
+		return false
+	}
+	if alive, known := run.alive(); known {
+		return alive
+	}
+	return time.Since(run.lastWrite()) < followStaleAfter
+}
+
+// alive tells whether the process of the run still exists.
+// That is only known for runs on this host.
+func (r *runLog) alive() (alive, known bool) {
+	host, _ := os.Hostname()
+	if r.PID == 0 || r.Host != host {
+		return false, false
+	}
+	err := syscall.Kill(r.PID, 0)
+	return err == nil || err == syscall.EPERM, true
+}
+
+// lastWrite returns when any file of the run was last written to.
+func (r *runLog) lastWrite() time.Time {
+	last := time.Time{}
+	entries, _ := os.ReadDir(r.dir)
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+	return last
+}
+
+// grep prints lines matching re in the logs of all tasks, or only the given task.
+func (r *runLog) grep(w io.Writer, re *regexp.Regexp, onlyTask string) error {
+	taskNames, err := r.logFiles()
+	if err != nil {
+		return err
+	}
+
+	nameLen := 0
+	for _, taskName := range taskNames {
+		if len(taskName) > nameLen {
+			nameLen = len(taskName)
+		}
+	}
+
+	for _, taskName := range taskNames {
+		if onlyTask != "" && taskName != onlyTask {
+			continue
+		}
+
+		if err := func() error {
+			file, err := os.Open(r.taskPath(taskName))
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			scanner := bufio.NewScanner(file)
+			scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+			for scanner.Scan() {
+				if line := scanner.Text(); re.MatchString(line) {
+					fmt.Fprintf(w, "%-*s  %s\n", nameLen, taskName, line)
+				}
+			}
+			return scanner.Err()
+		}(); err != nil {
+			return errors.WithMessagef(err, "reading log of %q", taskName)
+		}
+	}
+
+	return nil
+}
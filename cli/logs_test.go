@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newStoppedRun creates a run that is still marked as running
+// and has written a log for the task "a".
+func newStoppedRun(t *testing.T, pid int) *runLog {
+	t.Helper()
+
+	run, err := newRunLog(t.TempDir(), "a", "cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	run.PID = pid
+	if err := run.save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(run.taskPath("a"), []byte("out | hello\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return run
+}
+
+// followLogs follows the log of "a" and fails if that doesn't stop in time.
+func followLogs(t *testing.T, run *runLog) string {
+	t.Helper()
+
+	out := &bytes.Buffer{}
+	done := make(chan error, 1)
+	go func() { done <- run.show(out, "a", true) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("following the logs of a stopped run never returned")
+	}
+	return out.String()
+}
+
+func TestFollowRunWithoutProcess(t *testing.T) {
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skip(err)
+	}
+
+	run := newStoppedRun(t, cmd.Process.Pid)
+	if out := followLogs(t, run); out != "out | hello\n" {
+		t.Errorf("unexpected output %q", out)
+	}
+}
+
+func TestFollowStaleRun(t *testing.T) {
+	// a run on another host, whose process can't be checked
+	run := newStoppedRun(t, 1)
+	run.Host = "elsewhere"
+	if err := run.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-2 * followStaleAfter)
+	entries, err := os.ReadDir(run.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if err := os.Chtimes(filepath.Join(run.dir, entry.Name()), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	followLogs(t, run)
+}
+
+func TestRunningWhileProcessExists(t *testing.T) {
+	run := newStoppedRun(t, os.Getpid())
+	if !run.running() {
+		t.Error("run of a live process is not running")
+	}
+}
+
+func TestTaskLogNames(t *testing.T) {
+	taskNames := []string{"a/b", "a_b", "a%2Fb", "Build", "build", "a b", "ä", "lint.go-1"}
+
+	seen := map[string]string{}
+	for _, taskName := range taskNames {
+		logName := taskLogName(taskName)
+		folded := strings.ToLower(logName)
+		if other, ok := seen[folded]; ok {
+			t.Errorf("%q and %q share the log file %q", taskName, other, logName)
+		}
+		seen[folded] = taskName
+
+		if strings.ContainsAny(logName, "/\x00") {
+			t.Errorf("log file %q of %q is not a plain file name", logName, taskName)
+		}
+		if back, err := logTaskName(logName); err != nil || back != taskName {
+			t.Errorf("log file %q maps back to %q (%v), expected %q", logName, back, err, taskName)
+		}
+	}
+
+	run, err := newRunLog(t.TempDir(), "a", "cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, taskName := range taskNames {
+		if err := os.WriteFile(run.taskPath(taskName), []byte(taskName), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	logged, err := run.logFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(taskNames)
+	if !reflect.DeepEqual(logged, taskNames) {
+		t.Errorf("expected logs of %q, got %q", taskNames, logged)
+	}
+	for _, taskName := range taskNames {
+		out := &bytes.Buffer{}
+		if err := run.show(out, taskName, false); err != nil || out.String() != taskName {
+			t.Errorf("log of %q is %q (%v)", taskName, out, err)
+		}
+	}
+}
+
+func TestPruneRunsKeepsRunning(t *testing.T) {
+	stateDir := t.TempDir()
+
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Skip(err)
+	}
+
+	ids := []string{}
+	for i, pid := range []int{os.Getpid(), exited.Process.Pid, 0, 0} {
+		run, err := newRunLog(stateDir, "a", "cli")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// IDs are ordered by time, only to the second
+		run.ID = fmt.Sprintf("20260101T00000%dZ-000000", i)
+		newDir := filepath.Join(runsDir(stateDir), run.ID)
+		if err := os.Rename(run.dir, newDir); err != nil {
+			t.Fatal(err)
+		}
+		run.dir = newDir
+		run.PID = pid
+		if pid == 0 {
+			run.Outcome = runOutcomeSuccess
+		}
+		if err := run.save(); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, run.ID)
+	}
+
+	if err := pruneRuns(stateDir, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	runs, err := loadRuns(stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := []string{}
+	for _, run := range runs {
+		kept = append(kept, run.ID)
+	}
+	sort.Strings(kept)
+
+	// the oldest run is still in progress, the crashed one is pruned instead
+	if expected := []string{ids[0], ids[2], ids[3]}; !reflect.DeepEqual(kept, expected) {
+		t.Errorf("expected to keep %v, got %v", expected, kept)
+	}
+}
+
+func TestTeeLogOfBuild(t *testing.T) {
+	run, err := newRunLog(t.TempDir(), "a", "cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := &bytes.Buffer{}
+	task := &Task{name: "a", runLog: run, cmd: exec.Command("nix", "build", "--json")}
+	task.cmd.Stdout = result
+	task.teeLog("build")
+
+	fmt.Fprintln(task.cmd.Stdout, `[{"drvPath":"/nix/store/a.drv"}]`)
+	fmt.Fprintln(task.cmd.Stderr, "building '/nix/store/a.drv'")
+	task.flushLog()
+	if err := task.logFile.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(run.taskPath("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "drvPath") || !strings.Contains(string(content), "err | building") {
+		t.Errorf("expected only the build log in the log file, got\n%s", content)
+	}
+	if !strings.Contains(result.String(), "drvPath") {
+		t.Errorf("the build result didn't reach the command's stdout: %q", result)
+	}
+}
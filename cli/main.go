@@ -1,52 +1,54 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
-	"strings"
+	"time"
 
 	arg "github.com/alexflint/go-arg"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 )
 
 var buildVersion = "dev"
 var buildCommit = "dirty"
 
-type RunSpec struct {
-	Dag map[string][]string `json:"dag"`
-	Bin map[string]string   `json:"bin"`
-}
-
-func (r RunSpec) MarshalZerologObject(event *zerolog.Event) {
-	dag := zerolog.Dict()
-	for k, v := range r.Dag {
-		dag.Strs(k, v)
-	}
-	event.Dict("Dag", dag)
-
-	bin := zerolog.Dict()
-	for k, v := range r.Bin {
-		bin.Str(k, v)
-	}
-	event.Dict("Bin", bin)
-}
-
 type Config struct {
-	LogLevel string `arg:"--log-level,env:LOG_LEVEL" default:"info" help:"one of trace,debug,info,warn,error,fatal,panic"`
-	Run      *Run   `arg:"subcommand:run" help:"execute the given task"`
-	List     *List  `arg:"subcommand:list" help:"show a list of available tasks"`
-	log      zerolog.Logger
+	LogLevel   string      `arg:"--log-level,env:LOG_LEVEL" default:"info" help:"one of trace,debug,info,warn,error,fatal,panic"`
+	Run        *Run        `arg:"subcommand:run" help:"execute the given task"`
+	List       *List       `arg:"subcommand:list" help:"show a list of available tasks"`
+	Logs       *Logs       `arg:"subcommand:logs" help:"show the output of tasks in past runs"`
+	History    *History    `arg:"subcommand:history" help:"show duration trends and failure rates of tasks"`
+	Why        *Why        `arg:"subcommand:why" help:"show why a task runs when running another one"`
+	Validate   *Validate   `arg:"subcommand:validate" help:"check the dependencies between tasks for problems"`
+	Graph      *Graph      `arg:"subcommand:graph" help:"analyze the dependencies between tasks"`
+	Completion *Completion `arg:"subcommand:completion" help:"output a shell completion script"`
+	Export     *Export     `arg:"subcommand:export" help:"build a task and write a run spec to run it later without evaluation"`
+	Schema     *Schema     `arg:"subcommand:schema" help:"output the JSON schema of run specs"`
+	log        zerolog.Logger
 }
 
 type Run struct {
-	Task      string `arg:"positional"`
-	DagFlake  string `arg:"--dag-flake,env:DAG_FLAKE" default:".#tullia.x86_64-linux.dag"`
-	Mode      string `arg:"--mode,env:MODE" default:"cli"`
-	Runtime   string `arg:"--runtime,env:RUNTIME" default:"nsjail"`
-	TaskFlake string `arg:"--task-flake,env:TASK_FLAKE" default:".#tullia.x86_64-linux.task"`
-	RunSpec   string `arg:"--run-spec,env:RUN_SPEC" help:"used internally. Start with @ to read from a file."`
-	runSpec   *RunSpec
+	Task           string        `arg:"positional" complete:"task"`
+	DagFlake       string        `arg:"--dag-flake,env:DAG_FLAKE" default:".#tullia.x86_64-linux.dag"`
+	Mode           string        `arg:"--mode,env:MODE" default:"cli" help:"one of cli,verbose,json,passthrough,github"`
+	Runtime        string        `arg:"--runtime,env:RUNTIME" default:"nsjail" help:"one of nsjail,podman,unwrapped"`
+	TaskFlake      string        `arg:"--task-flake,env:TASK_FLAKE" default:".#tullia.x86_64-linux.task"`
+	RunSpec        string        `arg:"--run-spec,env:RUN_SPEC" help:"tasks to run without evaluating the flake, see the export and schema commands. Start with @ to read from a file."`
+	Trace          string        `arg:"--trace,env:TRACE" help:"write a Chrome trace of the run to this file"`
+	OTelURL        string        `arg:"--otel-endpoint,env:OTEL_EXPORTER_OTLP_ENDPOINT" help:"export an OpenTelemetry trace of the run via OTLP/HTTP to this endpoint"`
+	OTelFile       string        `arg:"--otel-file,env:OTEL_FILE" help:"write an OpenTelemetry trace of the run as OTLP JSON to this file"`
+	Report         string        `arg:"--report,env:REPORT" help:"print the critical path of the run. one of text,json"`
+	StateDir       string        `arg:"--state-dir,env:TULLIA_STATE_DIR" default:".tullia" help:"directory for logs and other state of runs"`
+	Refresh        bool          `arg:"--refresh" help:"evaluate the tasks instead of using the cached evaluation"`
+	KeepRuns       int           `arg:"--keep-runs,env:KEEP_RUNS" default:"10" help:"number of runs to keep the logs of, 0 disables logs"`
+	Color          string        `arg:"--color,env:COLOR" default:"auto" help:"one of auto,always,never. auto honors NO_COLOR"`
+	Summary        string        `arg:"--summary,env:SUMMARY" default:"text" help:"summary of all tasks after the run. one of text,json,none"`
+	SummaryFile    string        `arg:"--summary-file,env:SUMMARY_FILE" help:"file to write the JSON summary to, - for stdout. defaults to summary.json next to the logs of the run"`
+	Prefix         bool          `arg:"--prefix,env:PREFIX" help:"prefix each line of output with the task name in passthrough mode while tasks run in parallel"`
+	StatusInterval time.Duration `arg:"--status-interval,env:STATUS_INTERVAL" default:"30s" help:"how often to print a status in cli mode without a terminal, 0 disables it"`
+	runSpec        *RunSpec
+	color          bool
 }
 
 func (d Run) MarshalZerologObject(event *zerolog.Event) {
@@ -55,19 +57,106 @@ func (d Run) MarshalZerologObject(event *zerolog.Event) {
 		Str("DagFlake", d.DagFlake).
 		Str("Mode", d.Mode).
 		Str("Runtime", d.Runtime).
-		Str("TaskFlake", d.TaskFlake)
+		Str("TaskFlake", d.TaskFlake).
+		Str("Trace", d.Trace).
+		Str("OTelURL", d.OTelURL).
+		Str("OTelFile", d.OTelFile).
+		Str("Report", d.Report).
+		Str("StateDir", d.StateDir).
+		Bool("Refresh", d.Refresh).
+		Int("KeepRuns", d.KeepRuns).
+		Str("Color", d.Color).
+		Str("Summary", d.Summary).
+		Str("SummaryFile", d.SummaryFile).
+		Bool("Prefix", d.Prefix).
+		Dur("StatusInterval", d.StatusInterval)
 	if d.runSpec != nil {
 		event.Object("RunSpec", d.runSpec)
 	}
 }
 
 type List struct {
-	DagFlake string `arg:"--dag-flake" default:".#tullia.x86_64-linux.dag"`
-	Style    string `arg:"--style" default:"compact" help:"one of compact,rounded,dotted,basic"`
+	Task      string `arg:"positional" complete:"task" help:"only show this task and everything it runs after"`
+	Reverse   bool   `arg:"--reverse" help:"show everything that runs after the task instead"`
+	DagFlake  string `arg:"--dag-flake" default:".#tullia.x86_64-linux.dag"`
+	TaskFlake string `arg:"--task-flake,env:TASK_FLAKE" default:".#tullia.x86_64-linux.task" help:"evaluated for the runtime of every task"`
+	StateDir  string `arg:"--state-dir,env:TULLIA_STATE_DIR" default:".tullia" help:"directory for logs and other state of runs"`
+	Refresh   bool   `arg:"--refresh" help:"evaluate the tasks instead of using the cached evaluation"`
+	Style     string `arg:"--style" default:"compact" help:"one of compact,rounded,dotted,basic"`
+	Format    string `arg:"--format" default:"tree" help:"one of tree,dot,mermaid,json"`
 }
 
 func (d List) MarshalZerologObject(event *zerolog.Event) {
-	event.Str("DagFlake", d.DagFlake)
+	event.
+		Str("Task", d.Task).
+		Bool("Reverse", d.Reverse).
+		Str("DagFlake", d.DagFlake).
+		Str("TaskFlake", d.TaskFlake).
+		Str("Format", d.Format)
+}
+
+type Validate struct {
+	DagFlake string `arg:"--dag-flake,env:DAG_FLAKE" default:".#tullia.x86_64-linux.dag"`
+	StateDir string `arg:"--state-dir,env:TULLIA_STATE_DIR" default:".tullia" help:"directory for logs and other state of runs"`
+	Refresh  bool   `arg:"--refresh" help:"evaluate the tasks instead of using the cached evaluation"`
+	JSON     bool   `arg:"--json" help:"output the problems as JSON"`
+	Strict   bool   `arg:"--strict" help:"fail on warnings as well"`
+}
+
+type Completion struct {
+	Args     []string `arg:"positional" help:"one of bash,zsh,fish"`
+	Complete bool     `arg:"--complete" help:"used by the completion scripts, prints candidates for the last of the given words"`
+	DagFlake string   `arg:"--dag-flake,env:DAG_FLAKE" default:".#tullia.x86_64-linux.dag"`
+	StateDir string   `arg:"--state-dir,env:TULLIA_STATE_DIR" default:".tullia" help:"directory for logs and other state of runs"`
+}
+
+type Schema struct{}
+
+type Export struct {
+	Task      string `arg:"positional,required" complete:"task" help:"task to export along with everything it runs after"`
+	Output    string `arg:"--output,-o" default:"-" help:"file to write the run spec to, - for stdout"`
+	To        string `arg:"--to" help:"also copy the built tasks to this nix store, e.g. a binary cache at file:///some/dir"`
+	DagFlake  string `arg:"--dag-flake,env:DAG_FLAKE" default:".#tullia.x86_64-linux.dag"`
+	TaskFlake string `arg:"--task-flake,env:TASK_FLAKE" default:".#tullia.x86_64-linux.task"`
+	Runtime   string `arg:"--runtime,env:RUNTIME" default:"nsjail" help:"one of nsjail,podman,unwrapped"`
+	StateDir  string `arg:"--state-dir,env:TULLIA_STATE_DIR" default:".tullia" help:"directory for logs and other state of runs"`
+	Refresh   bool   `arg:"--refresh" help:"evaluate the tasks instead of using the cached evaluation"`
+}
+
+type Graph struct {
+	Lint *GraphLint `arg:"subcommand:lint" help:"find dependencies that can be removed and undocumented tasks"`
+}
+
+type GraphLint struct {
+	DagFlake  string `arg:"--dag-flake,env:DAG_FLAKE" default:".#tullia.x86_64-linux.dag"`
+	StateDir  string `arg:"--state-dir,env:TULLIA_STATE_DIR" default:".tullia" help:"directory for logs and other state of runs"`
+	Refresh   bool   `arg:"--refresh" help:"evaluate the tasks instead of using the cached evaluation"`
+	TaskFlake string `arg:"--task-flake,env:TASK_FLAKE" default:".#tullia.x86_64-linux.task"`
+	JSON      bool   `arg:"--json" help:"output the findings and the reduced dependencies as JSON"`
+}
+
+type Why struct {
+	Target   string `arg:"positional,required" complete:"task" help:"task that would be run"`
+	Task     string `arg:"positional,required" complete:"task" help:"task that runs because of it"`
+	DagFlake string `arg:"--dag-flake,env:DAG_FLAKE" default:".#tullia.x86_64-linux.dag"`
+	StateDir string `arg:"--state-dir,env:TULLIA_STATE_DIR" default:".tullia" help:"directory for logs and other state of runs"`
+	Refresh  bool   `arg:"--refresh" help:"evaluate the tasks instead of using the cached evaluation"`
+}
+
+type Logs struct {
+	Task     string `arg:"positional" complete:"task" help:"task to show the log of. defaults to the last failed task"`
+	Run      string `arg:"--run" help:"ID (or prefix) of the run. defaults to the latest run"`
+	List     bool   `arg:"--list" help:"list past runs"`
+	Follow   bool   `arg:"--follow,-f" help:"keep showing new output until the run is finished"`
+	Grep     string `arg:"--grep" help:"show lines matching this regular expression in the logs of all tasks"`
+	StateDir string `arg:"--state-dir,env:TULLIA_STATE_DIR" default:".tullia" help:"directory for logs and other state of runs"`
+}
+
+type History struct {
+	Task     string `arg:"positional" complete:"task" help:"only show this task"`
+	Last     int    `arg:"--last" default:"50" help:"number of runs per task to consider, 0 for all"`
+	JSON     bool   `arg:"--json" help:"output JSON instead of a table"`
+	StateDir string `arg:"--state-dir,env:TULLIA_STATE_DIR" default:".tullia" help:"directory for logs and other state of runs"`
 }
 
 func Version() string {
@@ -111,26 +200,55 @@ func main() {
 	switch {
 	case config.List != nil:
 		if err := config.List.start(); err != nil {
-			log.Fatal().Err(err).Msg("starting list")
+			fatal(log, err, "starting list")
+		}
+	case config.Logs != nil:
+		if err := config.Logs.start(); err != nil {
+			log.Fatal().Err(err).Msg("showing logs")
+		}
+	case config.History != nil:
+		if err := config.History.start(); err != nil {
+			log.Fatal().Err(err).Msg("showing history")
+		}
+	case config.Validate != nil:
+		if err := config.Validate.start(); err != nil {
+			log.Fatal().Err(err).Msg("validating tasks")
+		}
+	case config.Graph != nil && config.Graph.Lint != nil:
+		if err := config.Graph.Lint.start(); err != nil {
+			log.Fatal().Err(err).Msg("linting tasks")
+		}
+	case config.Completion != nil:
+		if err := config.Completion.start(); err != nil {
+			log.Fatal().Err(err).Msg("completing")
+		}
+	case config.Export != nil:
+		if err := config.Export.start(); err != nil {
+			fatal(log, err, "exporting task")
+		}
+	case config.Schema != nil:
+		if err := config.Schema.start(); err != nil {
+			log.Fatal().Err(err).Msg("showing schema")
+		}
+	case config.Why != nil:
+		if err := config.Why.start(); err != nil {
+			fatal(log, err, "explaining dependency")
 		}
 	case config.Run != nil:
-		if len(config.Run.RunSpec) > 0 {
-			rs := &RunSpec{}
-
-			rsStr := []byte(config.Run.RunSpec)
-			if strings.HasPrefix(config.Run.RunSpec, "@") {
-				if contents, err := os.ReadFile(config.Run.RunSpec[1:]); err != nil {
-					log.Fatal().Err(err).Msg("reading run spec from file")
-				} else {
-					rsStr = contents
-				}
-			}
+		if color, err := useColor(config.Run.Color); err != nil {
+			log.Fatal().Err(err).Msg("setting color")
+		} else {
+			config.Run.color = color
+			log = log.Output(zerolog.NewConsoleWriter(func(w *zerolog.ConsoleWriter) { w.NoColor = !color }))
+			config.log = log
+		}
 
-			if err := json.Unmarshal(rsStr, rs); err != nil {
+		if len(config.Run.RunSpec) > 0 {
+			if rs, err := readRunSpec(config.Run.RunSpec); err != nil {
 				log.Fatal().Err(err).Msg("parsing run spec")
+			} else {
+				config.Run.runSpec = rs
 			}
-
-			config.Run.runSpec = rs
 		}
 
 		log.Debug().Object("config", config.Run).Msg("parsed args")
@@ -138,7 +256,7 @@ func main() {
 		if sv, err := supervisor(config); err != nil {
 			log.Fatal().Err(err).Msg("creating supervisor")
 		} else if err := sv.start(); err != nil {
-			log.Fatal().Err(err).Msg("starting supervisor")
+			fatal(log, err, "starting supervisor")
 		}
 		log.Debug().Msg("done")
 	default:
@@ -146,9 +264,27 @@ func main() {
 	}
 }
 
+// fatal logs the error and exits.
+// Unknown tasks are reported without decoration and with their own exit status.
+func fatal(log zerolog.Logger, err error, msg string) {
+	var notFound *taskNotFoundError
+	if errors.As(err, &notFound) {
+		fmt.Fprintln(os.Stderr, notFound.describe(terminalWidth(os.Stderr)))
+		os.Exit(exitTaskNotFound)
+	}
+	log.Fatal().Err(err).Msg(msg)
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
 	}
 	return b
 }
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
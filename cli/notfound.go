@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// exitTaskNotFound is the exit status when the requested task doesn't exist,
+// so scripts can tell a typo apart from a failed task.
+const exitTaskNotFound = 2
+
+// taskNotFoundError is returned when a task of the given name doesn't exist.
+type taskNotFoundError struct {
+	name  string
+	tasks []string
+}
+
+func (e *taskNotFoundError) Error() string {
+	return e.describe(80)
+}
+
+// describe lists the available tasks in columns that fit the given width.
+func (e *taskNotFoundError) describe(width int) string {
+	out := &strings.Builder{}
+	fmt.Fprintf(out, "Unknown task %q", e.name)
+	if suggestions := suggest(e.name, e.tasks, 3); len(suggestions) > 0 {
+		fmt.Fprintf(out, ", did you mean %s?", quoteAll(suggestions, " or "))
+	}
+	fmt.Fprintln(out)
+
+	if len(e.tasks) == 0 {
+		fmt.Fprint(out, "There are no tasks.")
+	} else {
+		fmt.Fprintln(out, "Available tasks:")
+		writeColumns(out, e.tasks, width)
+	}
+
+	return strings.TrimRight(out.String(), "\n")
+}
+
+// lookup returns a taskNotFoundError if the graph has no task of that name.
+func (g taskGraph) lookup(name string) error {
+	if _, ok := g[name]; !ok {
+		return &taskNotFoundError{name: name, tasks: g.names()}
+	}
+	return nil
+}
+
+// writeColumns lists the names in as many columns as fit, filled top to bottom like ls.
+func writeColumns(out *strings.Builder, names []string, width int) {
+	colWidth := 0
+	for _, name := range names {
+		colWidth = max(colWidth, len(name)+2)
+	}
+
+	cols := max(1, (width-2)/colWidth)
+	rows := (len(names) + cols - 1) / cols
+
+	for row := 0; row < rows; row++ {
+		line := "  "
+		for col := 0; col < cols; col++ {
+			if i := col*rows + row; i < len(names) {
+				line += fmt.Sprintf("%-*s", colWidth, names[i])
+			}
+		}
+		fmt.Fprintln(out, strings.TrimRight(line, " "))
+	}
+}
+
+func terminalWidth(f *os.File) int {
+	if width, _, err := term.GetSize(int(f.Fd())); err == nil && width > 0 {
+		return width
+	}
+	return 80
+}
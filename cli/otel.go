@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type traceID [16]byte
+type spanID [8]byte
+
+func (id traceID) String() string { return hex.EncodeToString(id[:]) }
+func (id spanID) String() string  { return hex.EncodeToString(id[:]) }
+func (id traceID) valid() bool    { return id != traceID{} }
+func (id spanID) valid() bool     { return id != spanID{} }
+
+func newTraceID() (id traceID) {
+	_, _ = rand.Read(id[:])
+	return
+}
+
+func newSpanID() (id spanID) {
+	_, _ = rand.Read(id[:])
+	return
+}
+
+// traceParent formats a W3C trace context header.
+// See https://www.w3.org/TR/trace-context/#traceparent-header
+func traceParent(trace traceID, span spanID) string {
+	return fmt.Sprintf("00-%s-%s-01", trace, span)
+}
+
+func parseTraceParent(header string) (trace traceID, span spanID, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return
+	}
+	if n, err := hex.Decode(trace[:], []byte(parts[1])); err != nil || n != len(trace) {
+		return
+	}
+	if n, err := hex.Decode(span[:], []byte(parts[2])); err != nil || n != len(span) {
+		return
+	}
+	return trace, span, trace.valid() && span.valid()
+}
+
+// otelTrace holds the identifiers of the trace for a run.
+// If tullia was started by a traced process, the run becomes part of its trace.
+type otelTrace struct {
+	traceID traceID
+	rootID  spanID
+	parent  spanID
+}
+
+func newOTelTrace() *otelTrace {
+	t := &otelTrace{traceID: newTraceID(), rootID: newSpanID()}
+	if trace, span, ok := parseTraceParent(os.Getenv("TRACEPARENT")); ok {
+		t.traceID, t.parent = trace, span
+	}
+	return t
+}
+
+// The types below follow the JSON encoding of OTLP.
+// See https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusOk         = 1
+	otlpStatusError      = 2
+)
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+func otlpString(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{StringValue: &value}}
+}
+
+func otlpInt(key string, value int) otlpAttribute {
+	i := strconv.Itoa(value)
+	return otlpAttribute{Key: key, Value: otlpValue{IntValue: &i}}
+}
+
+func otlpTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// otelSpans returns a root span for the run, a span per task,
+// and a span for every stage of a task.
+func (t *Tree) otelSpans() []otlpSpan {
+	trace := t.otel
+	runStart := t.evalStart
+	if runStart.IsZero() {
+		runStart = t.started
+	}
+
+	root := otlpSpan{
+		TraceID:           trace.traceID.String(),
+		SpanID:            trace.rootID.String(),
+		Name:              "tullia run " + t.config.Run.Task,
+		Kind:              otlpSpanKindInternal,
+		StartTimeUnixNano: otlpTime(runStart),
+		EndTimeUnixNano:   otlpTime(t.finished),
+		Attributes: []otlpAttribute{
+			otlpString("tullia.task", t.config.Run.Task),
+			otlpString("tullia.mode", t.config.Run.Mode),
+			otlpString("tullia.runtime", t.config.Run.Runtime),
+		},
+		Status: otlpStatus{Code: otlpStatusOk},
+	}
+	if trace.parent.valid() {
+		root.ParentSpanID = trace.parent.String()
+	}
+
+	spans := []otlpSpan{}
+
+	for _, task := range t.tasks() {
+		state := task.snapshot()
+		stages := state.stageSpans()
+		if len(stages) == 0 {
+			continue
+		}
+
+		attributes := []otlpAttribute{
+			otlpString("tullia.task", task.name),
+			otlpString("tullia.runtime", task.runtime()),
+			otlpString("tullia.stage", state.stage),
+		}
+		if task.drvPath != "" {
+			attributes = append(attributes, otlpString("tullia.drv_path", task.drvPath))
+		}
+		if task.storePath != "" {
+			attributes = append(attributes, otlpString("tullia.store_path", task.storePath))
+		}
+		if !state.runEnd.IsZero() {
+			attributes = append(attributes, otlpInt("process.exit_code", state.exitCode))
+		}
+
+		status := otlpStatus{Code: otlpStatusOk}
+		if state.err != nil {
+			status = otlpStatus{Code: otlpStatusError, Message: state.err.Error()}
+			root.Status = otlpStatus{Code: otlpStatusError, Message: fmt.Sprintf("%q failed", task.name)}
+		}
+
+		spans = append(spans, otlpSpan{
+			TraceID:           trace.traceID.String(),
+			SpanID:            task.spanID.String(),
+			ParentSpanID:      trace.rootID.String(),
+			Name:              task.name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: otlpTime(stages[0].start),
+			EndTimeUnixNano:   otlpTime(stages[len(stages)-1].end),
+			Attributes:        attributes,
+			Status:            status,
+		})
+
+		for i, stage := range stages {
+			stageStatus := otlpStatus{Code: otlpStatusOk}
+			if i == len(stages)-1 {
+				stageStatus = status
+			}
+			spans = append(spans, otlpSpan{
+				TraceID:           trace.traceID.String(),
+				SpanID:            newSpanID().String(),
+				ParentSpanID:      task.spanID.String(),
+				Name:              stage.name,
+				Kind:              otlpSpanKindInternal,
+				StartTimeUnixNano: otlpTime(stage.start),
+				EndTimeUnixNano:   otlpTime(stage.end),
+				Attributes:        []otlpAttribute{otlpString("tullia.task", task.name)},
+				Status:            stageStatus,
+			})
+		}
+	}
+
+	return append([]otlpSpan{root}, spans...)
+}
+
+func (t *Tree) otlpPayload() ([]byte, error) {
+	return json.Marshal(otlpTraces{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{Attributes: []otlpAttribute{
+				otlpString("service.name", "tullia"),
+				otlpString("service.version", Version()),
+			}},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "github.com/input-output-hk/tullia", Version: buildVersion},
+				Spans: t.otelSpans(),
+			}},
+		}},
+	})
+}
+
+// exportOTel sends the trace of the run to the configured endpoint and/or file.
+func (t *Tree) exportOTel() error {
+	payload, err := t.otlpPayload()
+	if err != nil {
+		return errors.WithMessage(err, "encoding OTLP payload")
+	}
+
+	if t.config.Run.OTelFile != "" {
+		if err := os.WriteFile(t.config.Run.OTelFile, append(payload, '\n'), 0o644); err != nil {
+			return errors.WithMessage(err, "writing OTLP file")
+		}
+	}
+
+	if t.config.Run.OTelURL != "" {
+		url := t.config.Run.OTelURL
+		if !strings.HasSuffix(url, "/v1/traces") {
+			url = strings.TrimSuffix(url, "/") + "/v1/traces"
+		}
+
+		client := &http.Client{Timeout: 10 * time.Second}
+		res, err := client.Post(url, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			return errors.WithMessagef(err, "sending trace to %s", url)
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode < 200 || res.StatusCode > 299 {
+			return fmt.Errorf("sending trace to %s: %s", url, res.Status)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTraceParentRoundTrip(t *testing.T) {
+	trace, span := newTraceID(), newSpanID()
+
+	gotTrace, gotSpan, ok := parseTraceParent(traceParent(trace, span))
+	if !ok || gotTrace != trace || gotSpan != span {
+		t.Errorf("expected %s %s, got %s %s (ok %t)", trace, span, gotTrace, gotSpan, ok)
+	}
+
+	for _, header := range []string{
+		"",
+		"00-" + trace.String() + "-" + span.String(),
+		"ff-" + trace.String() + "-" + span.String() + "-01",
+		"00-" + traceID{}.String() + "-" + span.String() + "-01",
+		"00-" + trace.String() + "-" + spanID{}.String() + "-01",
+		"00-xyz-" + span.String() + "-01",
+	} {
+		if _, _, ok := parseTraceParent(header); ok {
+			t.Errorf("expected %q to be rejected", header)
+		}
+	}
+}
+
+func TestExportOTel(t *testing.T) {
+	parentTrace, parentSpan := newTraceID(), newSpanID()
+	t.Setenv("TRACEPARENT", traceParent(parentTrace, parentSpan))
+
+	var path, contentType string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, contentType = r.URL.Path, r.Header.Get("Content-Type")
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	tree := newTestTree(t, Run{Task: "top", OTelURL: server.URL}, `{"version": 1, "tasks": {
+		"a": {"after": [], "bin": "/bin/true"},
+		"top": {"after": ["a"], "bin": "/bin/true"}
+	}}`)
+	if err := tree.start(); err != nil {
+		t.Fatalf("running tasks: %s", err)
+	}
+	if err := tree.exportOTel(); err != nil {
+		t.Fatalf("exporting trace: %s", err)
+	}
+
+	if path != "/v1/traces" || contentType != "application/json" {
+		t.Errorf("expected JSON posted to /v1/traces, got %s to %s", contentType, path)
+	}
+
+	traces := otlpTraces{}
+	if err := json.Unmarshal(body, &traces); err != nil {
+		t.Fatalf("decoding %s: %s", body, err)
+	}
+	if len(traces.ResourceSpans) != 1 || len(traces.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("expected one resource and scope, got %s", body)
+	}
+
+	resource := map[string]string{}
+	for _, attribute := range traces.ResourceSpans[0].Resource.Attributes {
+		if attribute.Value.StringValue != nil {
+			resource[attribute.Key] = *attribute.Value.StringValue
+		}
+	}
+	if resource["service.name"] != "tullia" || resource["service.version"] != Version() {
+		t.Errorf("unexpected resource attributes %v", resource)
+	}
+
+	spans := traces.ResourceSpans[0].ScopeSpans[0].Spans
+	root := spans[0]
+	if root.SpanID != tree.otel.rootID.String() || root.ParentSpanID != parentSpan.String() {
+		t.Errorf("expected root span %s with parent %s, got %s with parent %s",
+			tree.otel.rootID, parentSpan, root.SpanID, root.ParentSpanID)
+	}
+
+	taskSpans := map[string]string{}
+	for _, task := range tree.tasks() {
+		taskSpans[task.spanID.String()] = task.name
+	}
+
+	stages := map[string][]string{}
+	for _, span := range spans {
+		if span.TraceID != parentTrace.String() {
+			t.Errorf("span %s is not part of the parent trace %s: %s", span.Name, parentTrace, span.TraceID)
+		}
+		if name, ok := taskSpans[span.SpanID]; ok {
+			if span.Name != name || span.ParentSpanID != root.SpanID {
+				t.Errorf("expected span of %s to be a child of the root span, got %+v", name, span)
+			}
+		} else if name, ok := taskSpans[span.ParentSpanID]; ok {
+			stages[name] = append(stages[name], span.Name)
+		} else if span.SpanID != root.SpanID {
+			t.Errorf("span %s has an unknown parent %s", span.Name, span.ParentSpanID)
+		}
+	}
+
+	if len(taskSpans) != 2 {
+		t.Errorf("expected spans for 2 tasks, got %v", taskSpans)
+	}
+	for _, name := range []string{"a", "top"} {
+		if got := stages[name]; len(got) != 2 || got[0] != "wait" || got[1] != "run" {
+			t.Errorf("expected wait and run stages of %s, got %v", name, got)
+		}
+	}
+}
+
+func TestExportOTelFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	tree := newTestTree(t, Run{Task: "a", OTelURL: server.URL + "/v1/traces"}, `{"version": 1, "tasks": {
+		"a": {"after": [], "bin": "/bin/true"}
+	}}`)
+	if err := tree.start(); err != nil {
+		t.Fatalf("running tasks: %s", err)
+	}
+	if err := tree.exportOTel(); err == nil {
+		t.Error("expected an error when the collector rejects the trace")
+	}
+}
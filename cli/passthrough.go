@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"golang.org/x/term"
+)
+
+// preExecPassthrough hands the terminal to the run of the target task only.
+// Everything else runs at the same time as other tasks, so its output is
+// either prefixed with the task name or captured and shown if it fails.
+func (t *Task) preExecPassthrough() {
+	if t.cmd.Env == nil {
+		t.cmd.Env = os.Environ()
+	}
+
+	if t.name == t.config.Run.Task && t.snapshot().stage == "run" {
+		t.attachTerminal()
+		return
+	}
+
+	if t.config.Run.Prefix {
+		t.prefixOutput()
+		return
+	}
+
+	t.captured = &bytes.Buffer{}
+	captured := &syncWriter{mutex: &sync.Mutex{}, w: t.captured}
+	if t.cmd.Stdout == nil {
+		t.cmd.Stdout = captured
+	}
+	if t.cmd.Stderr == nil {
+		t.cmd.Stderr = captured
+	}
+}
+
+// attachTerminal runs the command in a pseudo terminal connected to ours,
+// so interactive programs work like they were started directly.
+func (t *Task) attachTerminal() {
+	if isTerminal(os.Stdin) && isTerminal(os.Stdout) {
+		master, slave, err := openPTY()
+		if err == nil {
+			t.pty = &ptySession{master: master, slave: slave}
+			t.cmd.Stdin = slave
+			t.cmd.Stdout = slave
+			t.cmd.Stderr = slave
+			t.cmd.SysProcAttr = ptyAttr()
+			return
+		}
+		t.log.Warn().Err(err).Msg("allocating a pseudo terminal")
+	}
+
+	t.cmd.Stdin = os.Stdin
+	t.cmd.Stdout = os.Stdout
+	t.cmd.Stderr = os.Stderr
+}
+
+func (t *Task) postExecPassthrough(stage string, f func(), err error) error {
+	if err != nil && t.captured != nil && t.captured.Len() > 0 {
+		fmt.Fprintf(os.Stderr, "Output of %s:\n", t.name)
+		_, _ = os.Stderr.Write(t.captured.Bytes())
+	}
+	t.captured = nil
+
+	return t.postExecCommon(stage, f, err)
+}
+
+// ptySession connects our terminal to the pseudo terminal of a command.
+type ptySession struct {
+	master *os.File
+	slave  *os.File
+	state  *term.State
+	winch  chan os.Signal
+	copied chan struct{}
+	// closing stopInput stops copying our input, inputDone is closed once it stopped
+	stopInput *os.File
+	inputDone chan struct{}
+}
+
+// start puts our terminal into raw mode, so every key press reaches the command,
+// and copies between the terminals until the command exits.
+func (p *ptySession) start() {
+	// The command has its own copy, ours would keep the pty open forever.
+	p.slave.Close()
+
+	_ = resizePTY(p.master, os.Stdin)
+	p.winch = make(chan os.Signal, 1)
+	signal.Notify(p.winch, syscall.SIGWINCH)
+	go func() {
+		for range p.winch {
+			_ = resizePTY(p.master, os.Stdin)
+		}
+	}()
+
+	if state, err := term.MakeRaw(int(os.Stdin.Fd())); err == nil {
+		p.state = state
+	}
+
+	if r, w, err := os.Pipe(); err == nil {
+		p.stopInput = w
+		p.inputDone = make(chan struct{})
+		go func() {
+			copyInput(p.master, os.Stdin, r)
+			r.Close()
+			close(p.inputDone)
+		}()
+	}
+
+	p.copied = make(chan struct{})
+	go func() {
+		_, _ = io.Copy(os.Stdout, p.master)
+		close(p.copied)
+	}()
+}
+
+// stop waits for the remaining output of the command and restores our terminal.
+func (p *ptySession) stop() {
+	if p.copied == nil {
+		p.slave.Close()
+	} else {
+		<-p.copied
+		signal.Stop(p.winch)
+		close(p.winch)
+	}
+
+	if p.stopInput != nil {
+		p.stopInput.Close()
+		<-p.inputDone
+	}
+
+	if p.state != nil {
+		_ = term.Restore(int(os.Stdin.Fd()), p.state)
+	}
+	p.master.Close()
+}
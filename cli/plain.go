@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// plainReporter renders a run in cli mode when there is no terminal to draw on.
+// It prints a line for every stage change and a compact status at an interval,
+// which reads well in CI logs and when piped to a file.
+type plainReporter struct {
+	tree      *Tree
+	out       io.Writer
+	interval  time.Duration
+	estimates map[string]*taskStats
+	started   time.Time
+	views     map[string]*taskView
+}
+
+func newPlainReporter(tree *Tree, out io.Writer, interval time.Duration) *plainReporter {
+	r := &plainReporter{
+		tree:     tree,
+		out:      out,
+		interval: interval,
+		started:  time.Now(),
+		views:    map[string]*taskView{},
+	}
+	for _, task := range tree.selectedTasks() {
+		r.views[task.name] = &taskView{name: task.name, output: &bytes.Buffer{}}
+	}
+	return r
+}
+
+// report prints events until the subscription is closed.
+func (r *plainReporter) report(s *subscription) {
+	events := make(chan event)
+	go func() {
+		defer close(events)
+		for {
+			e, ok := s.next()
+			if !ok {
+				return
+			}
+			events <- e
+		}
+	}()
+
+	var tick <-chan time.Time
+	if r.interval > 0 {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			r.handleEvent(e)
+		case <-tick:
+			if !r.idle() {
+				fmt.Fprintln(r.out, r.statusLine(time.Now()))
+			}
+		}
+	}
+}
+
+func (r *plainReporter) handleEvent(e event) {
+	view, ok := r.views[e.taskName()]
+	if !ok {
+		return
+	}
+
+	switch e := e.(type) {
+	case stateEvent:
+		changed := e.state.stage != view.stage
+		if changed && (e.state.stage == "build" || e.state.stage == "run") {
+			view.output.Reset()
+		}
+		view.taskState = e.state
+		if changed {
+			r.printStage(view)
+		}
+	case outputEvent:
+		view.output.Write(e.data)
+	}
+}
+
+func (r *plainReporter) printStage(view *taskView) {
+	var icon, duration string
+	var color lipgloss.Color
+	switch view.stage {
+	case "eval", "build", "run":
+		icon, color = "+", teal
+	case "done":
+		icon, color = "✔", green
+		duration = time.Since(view.startedAt()).Round(time.Millisecond).String()
+	case "error":
+		icon, color = "✗", red
+		duration = time.Since(view.startedAt()).Round(time.Millisecond).String()
+	case "cancel":
+		icon, color = "✗", teal
+	case "skip":
+		icon, color = "-", dim
+	default:
+		// waiting between build and run is not worth a line
+		return
+	}
+
+	line := fmt.Sprintf("[%s] %-6s %s", icon, view.stage, view.name)
+	if duration != "" {
+		line += "  " + duration
+	}
+	fmt.Fprintln(r.out, lipgloss.NewStyle().Foreground(color).Render(line))
+
+	if view.stage != "error" {
+		return
+	}
+
+	styleLog := lipgloss.NewStyle().Foreground(grey)
+	for _, l := range strings.Split(view.output.String(), "\n") {
+		if l = strings.TrimRight(l, "\r "); l != "" {
+			fmt.Fprintln(r.out, styleLog.Render("    "+l))
+		}
+	}
+	if view.err != nil {
+		fmt.Fprintln(r.out, "    "+view.err.Error())
+	}
+}
+
+// idle is true once all tasks finished.
+func (r *plainReporter) idle() bool {
+	for _, view := range r.views {
+		switch view.stage {
+		case "done", "error", "cancel", "skip":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// statusLine summarizes the run, e.g.
+// "[1m5s] running: a (build 12s), b (run 3s) • 2 waiting • 4 done • ETA 40s".
+func (r *plainReporter) statusLine(now time.Time) string {
+	running := []string{}
+	var waiting, done, failed int
+	for _, task := range r.tree.selectedTasks() {
+		view := r.views[task.name]
+		switch view.stage {
+		case "eval", "build", "run":
+			if start := view.stageStart(); start.IsZero() {
+				running = append(running, fmt.Sprintf("%s (%s)", view.name, view.stage))
+			} else {
+				running = append(running, fmt.Sprintf("%s (%s %s)",
+					view.name, view.stage, now.Sub(start).Round(time.Second)))
+			}
+		case "", "wait":
+			waiting++
+		case "done", "skip":
+			done++
+		case "error", "cancel":
+			failed++
+		}
+	}
+
+	parts := []string{}
+	if len(running) > 0 {
+		parts = append(parts, "running: "+strings.Join(running, ", "))
+	}
+	parts = append(parts,
+		fmt.Sprintf("%d waiting", waiting),
+		fmt.Sprintf("%d done", done),
+	)
+	if failed > 0 {
+		parts = append(parts, fmt.Sprintf("%d failed", failed))
+	}
+	if len(r.estimates) > 0 {
+		left, complete := eta(r.estimates, r.tree.selectedTasks(), r.views, now)
+		etaOut := left.Round(time.Second).String()
+		if !complete {
+			etaOut = ">" + etaOut
+		}
+		parts = append(parts, "ETA "+etaOut)
+	}
+
+	status := fmt.Sprintf("[%s] %s", now.Sub(r.started).Round(time.Second), strings.Join(parts, " • "))
+	return lipgloss.NewStyle().Foreground(dim).Render(status)
+}
+
+// stageStart returns when the current stage of the task started,
+// or the zero time if it has none or didn't record it.
+func (t taskState) stageStart() time.Time {
+	switch t.stage {
+	case "eval":
+		return t.evalStart
+	case "build":
+		return t.buildStart
+	case "run":
+		return t.runStart
+	default:
+		return time.Time{}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusLineCountsWaitingTasks(t *testing.T) {
+	tree := newTestTree(t, Run{Task: "top"}, `{"version": 1, "tasks": {
+		"a": {"after": [], "bin": "/bin/true"},
+		"b": {"after": [], "bin": "/bin/true"},
+		"c": {"after": [], "bin": "/bin/true"},
+		"top": {"after": ["a", "b", "c"], "bin": "/bin/true"}
+	}}`)
+	r := newPlainReporter(tree, &bytes.Buffer{}, 0)
+
+	now := time.Now()
+	r.handleEvent(stateEvent{task: "a", state: taskState{stage: "run", runStart: now.Add(-3 * time.Second)}})
+	r.handleEvent(stateEvent{task: "b", state: taskState{stage: "eval"}})
+	r.handleEvent(stateEvent{task: "c", state: taskState{stage: "wait", waitStart: now.Add(-time.Minute)}})
+	r.handleEvent(stateEvent{task: "top", state: taskState{stage: "wait"}})
+
+	status := r.statusLine(now)
+	for _, expected := range []string{"running: a (run 3s), b (eval) •", "2 waiting", "0 done"} {
+		if !strings.Contains(status, expected) {
+			t.Errorf("expected %q in status line %q", expected, status)
+		}
+	}
+	if strings.Contains(status, "c (") || strings.Contains(status, "top (") {
+		t.Errorf("waiting tasks are not running: %q", status)
+	}
+}
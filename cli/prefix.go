@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// prefixMutex serializes writes of prefixed lines, so lines of tasks
+// running in parallel never end up inside each other.
+var prefixMutex sync.Mutex
+
+// prefixColors are cycled through to tell tasks apart, like docker-compose does.
+var prefixColors = []lipgloss.Color{"6", "3", "2", "5", "4", "14", "11", "10", "13", "12"}
+
+// assignPrefixes gives every selected task a colored name padded to the longest one.
+func (t *Tree) assignPrefixes() {
+	width := 0
+	for _, task := range t.run {
+		width = max(width, len(task.name))
+	}
+
+	for i, task := range t.run {
+		style := lipgloss.NewStyle().Foreground(prefixColors[i%len(prefixColors)])
+		task.prefix = style.Render(fmt.Sprintf("%-*s |", width, task.name)) + " "
+	}
+}
+
+// prefixWriter writes every complete line with the given prefix.
+type prefixWriter struct {
+	out     io.Writer
+	prefix  string
+	partial bytes.Buffer
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.partial.Write(p)
+	for {
+		i := bytes.IndexByte(w.partial.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		w.writeLine(w.partial.Next(i + 1))
+	}
+	return len(p), nil
+}
+
+func (w *prefixWriter) writeLine(line []byte) {
+	prefixMutex.Lock()
+	defer prefixMutex.Unlock()
+	fmt.Fprintf(w.out, "%s%s", w.prefix, line)
+}
+
+// flush writes an incomplete last line.
+func (w *prefixWriter) flush() {
+	if w.partial.Len() > 0 {
+		w.writeLine(append(w.partial.Bytes(), '\n'))
+		w.partial.Reset()
+	}
+}
+
+// prefixOutput writes the output of the command with the task name in front of every line.
+func (t *Task) prefixOutput() {
+	stdout := &prefixWriter{out: os.Stdout, prefix: t.prefix}
+	stderr := &prefixWriter{out: os.Stderr, prefix: t.prefix}
+	t.prefixWriters = []*prefixWriter{stdout, stderr}
+	if t.cmd.Stdout == nil {
+		t.cmd.Stdout = stdout
+	}
+	if t.cmd.Stderr == nil {
+		t.cmd.Stderr = stderr
+	}
+}
+
+// flushPrefixed writes incomplete last lines once a command exited.
+func (t *Task) flushPrefixed() {
+	for _, w := range t.prefixWriters {
+		w.flush()
+	}
+	t.prefixWriters = nil
+}
@@ -0,0 +1,94 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// openPTY allocates a pseudo terminal and returns both of its ends.
+func openPTY() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "opening /dev/ptmx")
+	}
+
+	fd := int(master.Fd())
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		return nil, nil, errors.WithMessage(err, "unlocking pty")
+	}
+
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, nil, errors.WithMessage(err, "getting pty number")
+	}
+
+	slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, errors.WithMessagef(err, "opening /dev/pts/%d", n)
+	}
+
+	return master, slave, nil
+}
+
+// resizePTY gives the pseudo terminal the size of the given terminal.
+func resizePTY(master, from *os.File) error {
+	size, err := unix.IoctlGetWinsize(int(from.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return err
+	}
+	return unix.IoctlSetWinsize(int(master.Fd()), unix.TIOCSWINSZ, size)
+}
+
+// ptyAttr starts the command in a new session with its stdin,
+// the pseudo terminal, as the controlling terminal.
+// The session leader also leads its own process group, so it can be killed like any other command.
+func ptyAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+}
+
+// copyInput copies from src to dst until the write end of cancel is closed.
+// It waits for input with poll, so nothing is read from src once it is canceled.
+func copyInput(dst io.Writer, src, cancel *os.File) {
+	buf := make([]byte, 4096)
+	fds := []unix.PollFd{
+		{Fd: int32(src.Fd()), Events: unix.POLLIN},
+		{Fd: int32(cancel.Fd()), Events: unix.POLLIN},
+	}
+
+	for {
+		fds[0].Revents, fds[1].Revents = 0, 0
+		if _, err := unix.Poll(fds, -1); err == unix.EINTR {
+			continue
+		} else if err != nil {
+			return
+		}
+
+		if fds[1].Revents != 0 {
+			return
+		}
+		if fds[0].Revents == 0 {
+			continue
+		}
+
+		n, err := unix.Read(int(fds[0].Fd), buf)
+		if err == unix.EINTR || err == unix.EAGAIN {
+			continue
+		} else if err != nil || n == 0 {
+			return
+		}
+		if _, err := dst.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCopyInputStopsWithoutConsumingInput(t *testing.T) {
+	pipe := func() (*os.File, *os.File) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { r.Close(); w.Close() })
+		return r, w
+	}
+	srcR, srcW := pipe()
+	dstR, dstW := pipe()
+	cancelR, cancelW := pipe()
+
+	done := make(chan struct{})
+	go func() {
+		copyInput(dstW, srcR, cancelR)
+		close(done)
+	}()
+
+	if _, err := srcW.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	copied := make([]byte, 3)
+	if _, err := io.ReadFull(dstR, copied); err != nil || string(copied) != "abc" {
+		t.Fatalf("expected abc to be copied, got %q (%v)", copied, err)
+	}
+
+	cancelW.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("copying input didn't stop")
+	}
+
+	// the next key press belongs to whoever reads after the session
+	if _, err := srcW.Write([]byte("next")); err != nil {
+		t.Fatal(err)
+	}
+	next := make([]byte, 4)
+	if _, err := io.ReadFull(srcR, next); err != nil || string(next) != "next" {
+		t.Fatalf("expected input after the session to be left unread, got %q (%v)", next, err)
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+func openPTY() (master, slave *os.File, err error) {
+	return nil, nil, errors.New("pseudo terminals are not supported on this platform")
+}
+
+func resizePTY(master, from *os.File) error {
+	return nil
+}
+
+func ptyAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+// copyInput is never called, as there are no pseudo terminals to copy to.
+func copyInput(dst io.Writer, src, cancel *os.File) {}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// pathStep is one stage of a task on the critical path.
+type pathStep struct {
+	Task     string        `json:"task"`
+	Stage    string        `json:"stage"`
+	Duration time.Duration `json:"-"`
+	Seconds  float64       `json:"seconds"`
+}
+
+// criticalPath finds the longest chain of stages through the given tasks.
+// A task can only run once it is built and all of its dependencies are done,
+// while builds don't wait for anything, so the earliest a task can be done is
+//
+//	max(build(task), max(done(dependency)...)) + run(task)
+//
+// The returned duration is the minimum time needed to run all tasks.
+func criticalPath(tasks []*Task, build, run func(*Task) time.Duration) (time.Duration, []pathStep) {
+	included := map[string]bool{}
+	for _, task := range tasks {
+		included[task.name] = true
+	}
+
+	done := map[string]time.Duration{}
+	via := map[string]*Task{}
+
+	var finish func(*Task) time.Duration
+	finish = func(task *Task) time.Duration {
+		if d, ok := done[task.name]; ok {
+			return d
+		}
+
+		start := build(task)
+		for _, vert := range task.predecessors {
+			predecessor := vert.Value.(*Task)
+			if !included[predecessor.name] {
+				continue
+			}
+			if d := finish(predecessor); d > start {
+				start = d
+				via[task.name] = predecessor
+			}
+		}
+
+		done[task.name] = start + run(task)
+		return done[task.name]
+	}
+
+	var last *Task
+	var total time.Duration
+	for _, task := range tasks {
+		if d := finish(task); last == nil || d > total {
+			last, total = task, d
+		}
+	}
+
+	path := []pathStep{}
+	for task := last; task != nil; task = via[task.name] {
+		path = append(path, pathStep{Task: task.name, Stage: "run", Duration: run(task)})
+		if via[task.name] == nil && build(task) > 0 {
+			path = append(path, pathStep{Task: task.name, Stage: "build", Duration: build(task)})
+		}
+	}
+
+	// reverse to get chronological order
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	for i := range path {
+		path[i].Seconds = path[i].Duration.Seconds()
+	}
+
+	return total, path
+}
+
+func spanDuration(start, end time.Time) time.Duration {
+	if start.IsZero() || end.IsZero() {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// buildDuration is the time spent evaluating and building the task.
+func (t taskState) buildDuration() time.Duration {
+	start := t.evalStart
+	if start.IsZero() {
+		start = t.buildStart
+	}
+	return spanDuration(start, t.buildEnd)
+}
+
+func (t taskState) runDuration() time.Duration {
+	return spanDuration(t.runStart, t.runEnd)
+}
+
+type runReport struct {
+	CriticalPath           []pathStep    `json:"criticalPath"`
+	MinimumWallTime        time.Duration `json:"-"`
+	MinimumWallTimeSeconds float64       `json:"minimumWallTimeSeconds"`
+	WallTime               time.Duration `json:"-"`
+	WallTimeSeconds        float64       `json:"wallTimeSeconds"`
+	BusyTime               time.Duration `json:"-"`
+	BusyTimeSeconds        float64       `json:"busyTimeSeconds"`
+	Parallelism            float64       `json:"parallelism"`
+}
+
+// report analyzes the timings of a finished run.
+func (t *Tree) report() runReport {
+	tasks := t.tasks()
+
+	r := runReport{WallTime: spanDuration(t.started, t.finished)}
+	r.MinimumWallTime, r.CriticalPath = criticalPath(tasks,
+		func(t *Task) time.Duration { return t.snapshot().buildDuration() },
+		func(t *Task) time.Duration { return t.snapshot().runDuration() },
+	)
+
+	for _, task := range tasks {
+		state := task.snapshot()
+		r.BusyTime += state.buildDuration() + state.runDuration()
+	}
+	if r.WallTime > 0 {
+		r.Parallelism = float64(r.BusyTime) / float64(r.WallTime)
+	}
+
+	r.MinimumWallTimeSeconds = r.MinimumWallTime.Seconds()
+	r.WallTimeSeconds = r.WallTime.Seconds()
+	r.BusyTimeSeconds = r.BusyTime.Seconds()
+
+	return r
+}
+
+// writeReport prints the report of the run to stderr, where it doesn't mix
+// with the output of tasks, or logs it in json mode.
+func (s *Supervisor) writeReport() error {
+	report := s.tree.report()
+	if s.config.Run.Mode == "json" {
+		if s.config.Run.Report != "json" && s.config.Run.Report != "text" {
+			return fmt.Errorf("Unknown report format: %q", s.config.Run.Report)
+		}
+		s.tree.log.Log().Str("level", "info").Interface("report", report).Msg("report")
+		return nil
+	}
+	return report.write(os.Stderr, s.config.Run.Report)
+}
+
+func (r runReport) write(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		return json.NewEncoder(w).Encode(r)
+	case "text":
+		nameLen := 0
+		for _, step := range r.CriticalPath {
+			if len(step.Task) > nameLen {
+				nameLen = len(step.Task)
+			}
+		}
+
+		fmt.Fprintln(w, "Critical path:")
+		for _, step := range r.CriticalPath {
+			fmt.Fprintf(w, "  %-5s %-*s  %s\n", step.Stage, nameLen, step.Task, step.Duration.Round(time.Millisecond))
+		}
+		fmt.Fprintf(w, "Wall time:         %s\n", r.WallTime.Round(time.Millisecond))
+		fmt.Fprintf(w, "Minimum wall time: %s\n", r.MinimumWallTime.Round(time.Millisecond))
+		fmt.Fprintf(w, "Parallelism:       %.2f (%s of work)\n", r.Parallelism, r.BusyTime.Round(time.Millisecond))
+		return nil
+	default:
+		return fmt.Errorf("Unknown report format: %q", format)
+	}
+}
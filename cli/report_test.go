@@ -0,0 +1,91 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCriticalPath(t *testing.T) {
+	// d runs after b and c, which both run after a
+	tree := newTestTree(t, Run{Task: "d"}, `{"version": 1, "tasks": {
+		"a": {"after": [], "bin": "/bin/true"},
+		"b": {"after": ["a"], "bin": "/bin/true"},
+		"c": {"after": ["a"], "bin": "/bin/true"},
+		"d": {"after": ["b", "c"], "bin": "/bin/true"}
+	}}`)
+
+	tasks := func(names ...string) []*Task {
+		tasks := []*Task{}
+		for _, name := range names {
+			task, err := tree.task(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tasks = append(tasks, task)
+		}
+		return tasks
+	}
+	durations := func(seconds map[string]int) func(*Task) time.Duration {
+		return func(task *Task) time.Duration { return time.Duration(seconds[task.name]) * time.Second }
+	}
+
+	for _, tc := range []struct {
+		name     string
+		tasks    []string
+		build    map[string]int
+		run      map[string]int
+		expected time.Duration
+		path     []string
+	}{
+		{
+			name:     "diamond",
+			tasks:    []string{"a", "b", "c", "d"},
+			build:    map[string]int{"a": 1, "b": 1, "c": 1, "d": 1},
+			run:      map[string]int{"a": 2, "b": 5, "c": 1, "d": 1},
+			expected: 9 * time.Second,
+			path:     []string{"build a", "run a", "run b", "run d"},
+		},
+		{
+			name:     "build dominates dependencies",
+			tasks:    []string{"a", "b", "c", "d"},
+			build:    map[string]int{"a": 1, "b": 1, "c": 1, "d": 20},
+			run:      map[string]int{"a": 2, "b": 5, "c": 1, "d": 1},
+			expected: 21 * time.Second,
+			path:     []string{"build d", "run d"},
+		},
+		{
+			name:     "predecessor not included",
+			tasks:    []string{"b", "c", "d"},
+			build:    map[string]int{"a": 100, "b": 1, "c": 3, "d": 1},
+			run:      map[string]int{"a": 100, "b": 1, "c": 1, "d": 1},
+			expected: 5 * time.Second,
+			path:     []string{"build c", "run c", "run d"},
+		},
+		{
+			name:     "single task",
+			tasks:    []string{"a"},
+			build:    map[string]int{},
+			run:      map[string]int{"a": 2},
+			expected: 2 * time.Second,
+			path:     []string{"run a"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			total, steps := criticalPath(tasks(tc.tasks...), durations(tc.build), durations(tc.run))
+			path := []string{}
+			for _, step := range steps {
+				path = append(path, step.Stage+" "+step.Task)
+				if step.Seconds != step.Duration.Seconds() {
+					t.Errorf("seconds of %+v don't match its duration", step)
+				}
+			}
+			if total != tc.expected {
+				t.Errorf("expected minimum of %s, got %s", tc.expected, total)
+			}
+			if !reflect.DeepEqual(path, tc.path) {
+				t.Errorf("expected path %v, got %v", tc.path, path)
+			}
+		})
+	}
+}
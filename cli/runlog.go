@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// runLog persists the output of all tasks of a run in its own directory
+// below `<state-dir>/runs`, along with a `run.json` describing the run.
+type runLog struct {
+	ID       string                 `json:"id"`
+	Target   string                 `json:"target"`
+	Mode     string                 `json:"mode"`
+	Started  time.Time              `json:"started"`
+	Finished time.Time              `json:"finished"`
+	Outcome  string                 `json:"outcome"`
+	Tasks    map[string]*runLogTask `json:"tasks"`
+	// the process of the run, to tell if it is still running
+	PID   int    `json:"pid,omitempty"`
+	Host  string `json:"host,omitempty"`
+	dir   string
+	mutex sync.Mutex
+}
+
+type runLogTask struct {
+	Stage string `json:"stage"`
+	Log   string `json:"log"`
+	Error string `json:"error,omitempty"`
+}
+
+const (
+	runOutcomeRunning = "running"
+	runOutcomeSuccess = "success"
+	runOutcomeFailure = "failure"
+)
+
+func runsDir(stateDir string) string {
+	return filepath.Join(stateDir, "runs")
+}
+
+func newRunID(now time.Time) string {
+	suffix := make([]byte, 3)
+	_, _ = rand.Read(suffix)
+	return now.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+}
+
+func newRunLog(stateDir, target, mode string) (*runLog, error) {
+	now := time.Now()
+	r := &runLog{
+		ID:      newRunID(now),
+		Target:  target,
+		Mode:    mode,
+		Started: now,
+		Outcome: runOutcomeRunning,
+		Tasks:   map[string]*runLogTask{},
+		PID:     os.Getpid(),
+	}
+	r.Host, _ = os.Hostname()
+	r.dir = filepath.Join(runsDir(stateDir), r.ID)
+
+	if err := os.MkdirAll(r.dir, 0o755); err != nil {
+		return nil, errors.WithMessage(err, "creating run log directory")
+	}
+
+	return r, r.save()
+}
+
+// taskLogName maps a task name to the name of its log file.
+// Everything but lower case letters, digits, '.', '_' and '-' is percent-escaped,
+// so distinct tasks never share a file, even on case-insensitive file systems.
+func taskLogName(taskName string) string {
+	name := strings.Builder{}
+	for i := 0; i < len(taskName); i++ {
+		switch c := taskName[i]; {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '.', c == '_', c == '-':
+			name.WriteByte(c)
+		default:
+			fmt.Fprintf(&name, "%%%02X", c)
+		}
+	}
+	return name.String() + ".log"
+}
+
+// logTaskName is the inverse of taskLogName.
+func logTaskName(logName string) (string, error) {
+	return url.PathUnescape(strings.TrimSuffix(logName, ".log"))
+}
+
+func (r *runLog) taskPath(taskName string) string {
+	return filepath.Join(r.dir, taskLogName(taskName))
+}
+
+func (r *runLog) save() error {
+	r.mutex.Lock()
+	content, err := json.MarshalIndent(r, "", "  ")
+	r.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// write atomically, `tullia logs` may be reading it concurrently
+	tmp := filepath.Join(r.dir, ".run.json")
+	if err := os.WriteFile(tmp, append(content, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(r.dir, "run.json"))
+}
+
+// finish records the outcome of the run and of all its tasks.
+func (r *runLog) finish(tasks []*Task, err error) error {
+	r.mutex.Lock()
+	r.Finished = time.Now()
+	if err == nil {
+		r.Outcome = runOutcomeSuccess
+	} else {
+		r.Outcome = runOutcomeFailure
+	}
+
+	for _, task := range tasks {
+		state := task.snapshot()
+		entry := &runLogTask{Stage: state.stage}
+		if task.logFile != nil {
+			if closeErr := task.logFile.Close(); closeErr != nil {
+				r.mutex.Unlock()
+				return closeErr
+			}
+			entry.Log = taskLogName(task.name)
+		}
+		if state.err != nil {
+			entry.Error = state.err.Error()
+		}
+		r.Tasks[task.name] = entry
+	}
+	r.mutex.Unlock()
+
+	return r.save()
+}
+
+// pruneRuns deletes all but the latest `keep` runs, except for runs still in progress.
+func pruneRuns(stateDir string, keep int) error {
+	dir := runsDir(stateDir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	ids := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			ids = append(ids, entry.Name())
+		}
+	}
+	sort.Strings(ids)
+
+	if len(ids) <= keep {
+		return nil
+	}
+
+	for _, id := range ids[:len(ids)-keep] {
+		// another tullia may still be writing to it
+		run := &runLog{dir: filepath.Join(dir, id)}
+		if run.running() {
+			continue
+		}
+		if err := os.RemoveAll(run.dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// taskLog writes the output of a task to a file,
+// prefixing each line with a timestamp and the stream it came from.
+type taskLog struct {
+	file  *os.File
+	mutex sync.Mutex
+}
+
+func openTaskLog(path string) (*taskLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &taskLog{file: file}, nil
+}
+
+func (l *taskLog) writeLine(stream string, line []byte) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	fmt.Fprintf(l.file, "%s %s | %s\n", time.Now().Format("2006-01-02T15:04:05.000Z07:00"), stream, line)
+}
+
+func (l *taskLog) Close() error {
+	return l.file.Close()
+}
+
+// taskLogStream buffers incomplete lines of one stream.
+type taskLogStream struct {
+	log    *taskLog
+	stream string
+	buf    bytes.Buffer
+}
+
+func (s *taskLogStream) Write(p []byte) (int, error) {
+	s.buf.Write(p)
+	for {
+		line, err := s.buf.ReadBytes('\n')
+		if err != nil {
+			s.buf.Write(line)
+			return len(p), nil
+		}
+		s.log.writeLine(s.stream, bytes.TrimRight(line, "\r\n"))
+	}
+}
+
+func (s *taskLogStream) flush() {
+	if s.buf.Len() > 0 {
+		s.log.writeLine(s.stream, s.buf.Bytes())
+		s.buf.Reset()
+	}
+}
+
+// teeLog additionally writes the output of the command to the task's log file.
+func (t *Task) teeLog(stage string) {
+	if t.logFile == nil {
+		logFile, err := openTaskLog(t.runLog.taskPath(t.name))
+		if err != nil {
+			t.log.Warn().Err(err).Msg("opening log file")
+			return
+		}
+		t.logFile = logFile
+	}
+
+	t.logFile.writeLine("tullia", []byte(fmt.Sprintf("stage %s: %s", stage, t.cmd)))
+
+	mutex := &sync.Mutex{}
+	tee := func(w io.Writer, stream string) io.Writer {
+		logStream := &taskLogStream{log: t.logFile, stream: stream}
+		t.logStreams = append(t.logStreams, logStream)
+		if w == nil {
+			return logStream
+		}
+		return io.MultiWriter(&syncWriter{mutex: mutex, w: w}, logStream)
+	}
+
+	// The output of the build is its result, only its log is worth keeping.
+	if stage != "build" {
+		t.cmd.Stdout = tee(t.cmd.Stdout, "out")
+	}
+	t.cmd.Stderr = tee(t.cmd.Stderr, "err")
+}
+
+// flushLog writes incomplete last lines to the log file once a command exited.
+func (t *Task) flushLog() {
+	for _, stream := range t.logStreams {
+		stream.flush()
+	}
+	t.logStreams = nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// runSpecVersion is the version of the run spec format written by the nix module.
+// Specs without a version are the original {dag, bin} format and are migrated.
+const runSpecVersion = 1
+
+//go:embed runspec.schema.json
+var runSpecSchema []byte
+
+// RunSpec describes prebuilt tasks to run without evaluating the flake.
+type RunSpec struct {
+	Version int                     `json:"version"`
+	Tasks   map[string]*RunSpecTask `json:"tasks"`
+}
+
+// RunSpecTask overrides how a single task is run.
+// Tasks without a bin are built like without a run spec.
+type RunSpecTask struct {
+	After       []string          `json:"after"`
+	Bin         string            `json:"bin,omitempty"`
+	Runtime     string            `json:"runtime,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+	Timeout     string            `json:"timeout,omitempty"`
+	Retries     int               `json:"retries,omitempty"`
+	WorkingDir  string            `json:"workingDir,omitempty"`
+	Description string            `json:"description,omitempty"`
+
+	timeout time.Duration
+}
+
+// runSpecV0 is the format before run specs were versioned.
+type runSpecV0 struct {
+	Dag map[string][]string `json:"dag"`
+	Bin map[string]string   `json:"bin"`
+}
+
+// parseRunSpec decodes a run spec of any known version, rejecting unknown fields.
+func parseRunSpec(data []byte) (*RunSpec, error) {
+	peek := struct {
+		Version *int `json:"version"`
+	}{}
+	if err := json.Unmarshal(data, &peek); err != nil {
+		return nil, errors.WithMessage(err, "decoding run spec")
+	}
+
+	spec := &RunSpec{}
+	switch {
+	case peek.Version == nil:
+		v0 := runSpecV0{}
+		if err := decodeStrict(data, &v0); err != nil {
+			return nil, errors.WithMessage(err, "decoding unversioned run spec")
+		}
+		migrated, err := v0.migrate()
+		if err != nil {
+			return nil, err
+		}
+		spec = migrated
+	case *peek.Version == runSpecVersion:
+		if err := decodeStrict(data, spec); err != nil {
+			return nil, errors.WithMessagef(err, "decoding run spec version %d", runSpecVersion)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported run spec version %d, expected %d", *peek.Version, runSpecVersion)
+	}
+
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+
+	return spec, nil
+}
+
+// readRunSpec parses the run spec given on the command line,
+// which is read from a file if it starts with @.
+func readRunSpec(arg string) (*RunSpec, error) {
+	data := []byte(arg)
+	if len(arg) > 0 && arg[0] == '@' {
+		contents, err := os.ReadFile(arg[1:])
+		if err != nil {
+			return nil, errors.WithMessage(err, "reading run spec from file")
+		}
+		data = contents
+	}
+	return parseRunSpec(data)
+}
+
+func decodeStrict(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return fmt.Errorf("unexpected data after the run spec")
+	}
+	return nil
+}
+
+func (v0 runSpecV0) migrate() (*RunSpec, error) {
+	spec := &RunSpec{Version: runSpecVersion, Tasks: map[string]*RunSpecTask{}}
+	for name, after := range v0.Dag {
+		spec.Tasks[name] = &RunSpecTask{After: after, Bin: v0.Bin[name]}
+	}
+	for name := range v0.Bin {
+		if _, ok := v0.Dag[name]; !ok {
+			return nil, fmt.Errorf("run spec has a bin for unknown task %q", name)
+		}
+	}
+	return spec, nil
+}
+
+// validate checks what the schema can't express to the decoder.
+func (r *RunSpec) validate() error {
+	if len(r.Tasks) == 0 {
+		return fmt.Errorf("run spec has no tasks")
+	}
+
+	for _, name := range r.names() {
+		task := r.Tasks[name]
+		if task == nil {
+			return fmt.Errorf("run spec task %q is null", name)
+		}
+
+		switch task.Runtime {
+		case "", "nsjail", "podman", "unwrapped":
+		default:
+			return fmt.Errorf("run spec task %q has unknown runtime %q, expected one of nsjail,podman,unwrapped", name, task.Runtime)
+		}
+
+		if task.Retries < 0 {
+			return fmt.Errorf("run spec task %q has negative retries", name)
+		}
+
+		if task.Timeout != "" {
+			timeout, err := time.ParseDuration(task.Timeout)
+			if err != nil {
+				return errors.WithMessagef(err, "run spec task %q has an invalid timeout", name)
+			}
+			if timeout <= 0 {
+				return fmt.Errorf("run spec task %q has a timeout that isn't positive", name)
+			}
+			task.timeout = timeout
+		}
+
+		if task.After == nil {
+			task.After = []string{}
+		}
+	}
+
+	return nil
+}
+
+func (r *RunSpec) names() []string {
+	names := make([]string, 0, len(r.Tasks))
+	for name := range r.Tasks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// dag returns the tasks each task runs after, like the evaluated DAG.
+func (r *RunSpec) dag() map[string][]string {
+	dag := map[string][]string{}
+	for name, task := range r.Tasks {
+		dag[name] = task.After
+	}
+	return dag
+}
+
+func (r RunSpec) MarshalZerologObject(event *zerolog.Event) {
+	event.Int("Version", r.Version)
+
+	tasks := zerolog.Dict()
+	for _, name := range r.names() {
+		task := r.Tasks[name]
+		tasks.Dict(name, zerolog.Dict().
+			Strs("After", task.After).
+			Str("Bin", task.Bin).
+			Str("Runtime", task.Runtime).
+			Str("Timeout", task.Timeout).
+			Int("Retries", task.Retries).
+			Str("WorkingDir", task.WorkingDir))
+	}
+	event.Dict("Tasks", tasks)
+}
+
+func (s Schema) start() error {
+	_, err := os.Stdout.Write(runSpecSchema)
+	return err
+}
+
+// runtime is the runtime the task is built with.
+func (t *Task) runtime() string {
+	if t.spec != nil && t.spec.Runtime != "" {
+		return t.spec.Runtime
+	}
+	return t.config.Run.Runtime
+}
+
+// prebuilt returns the executable of the task given by the run spec, if any.
+func (t *Task) prebuilt() string {
+	if t.spec == nil {
+		return ""
+	}
+	return t.spec.Bin
+}
+
+// applySpec sets the environment and working directory of the run command.
+func (t *Task) applySpec() {
+	if t.spec == nil {
+		return
+	}
+
+	t.cmd.Dir = t.spec.WorkingDir
+
+	if len(t.spec.Env) > 0 {
+		names := make([]string, 0, len(t.spec.Env))
+		for name := range t.spec.Env {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		t.cmd.Env = os.Environ()
+		for _, name := range names {
+			t.cmd.Env = append(t.cmd.Env, name+"="+t.spec.Env[name])
+		}
+	}
+}
+
+// startTimeout terminates the process group once the timeout of the task passed.
+// The returned function stops the timer.
+func (t *Task) startTimeout(pgid int) func() {
+	if t.spec == nil || t.spec.timeout == 0 {
+		return func() {}
+	}
+
+	timer := time.AfterFunc(t.spec.timeout, func() {
+		t.update(func(s *taskState) { s.timedOut = true })
+		_ = syscall.Kill(-pgid, syscall.SIGTERM)
+	})
+	return func() { timer.Stop() }
+}
+
+// retry lets a failed run wait to run again if the run spec allows more retries.
+// Tasks killed on request are never retried.
+func (t *Task) retry() bool {
+	if t.spec == nil || t.killed || t.snapshot().retries >= t.spec.Retries {
+		return false
+	}
+
+	t.log.Debug().Msg("retrying")
+	t.update(func(s *taskState) {
+		s.stage = "wait"
+		s.retries++
+		s.timedOut = false
+		s.exitCode = 0
+		s.runStart, s.runEnd = time.Time{}, time.Time{}
+		s.waitStart = time.Now()
+	})
+	return true
+}
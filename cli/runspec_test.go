@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRunSpec(t *testing.T) {
+	spec, err := parseRunSpec([]byte(`{"version": 1, "tasks": {
+		"lint": {"after": [], "bin": "/nix/store/abc-lint/bin/lint", "timeout": "90s"},
+		"test": {"after": ["lint"], "runtime": "podman", "retries": 2}
+	}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lint := spec.Tasks["lint"]; lint.timeout != 90*time.Second {
+		t.Errorf("expected a timeout of 90s, got %s", lint.timeout)
+	}
+	if test := spec.Tasks["test"]; test.Runtime != "podman" || test.Retries != 2 || test.timeout != 0 {
+		t.Errorf("unexpected task %+v", test)
+	}
+}
+
+func TestParseRunSpecV0(t *testing.T) {
+	spec, err := parseRunSpec([]byte(`{
+		"dag": {"lint": [], "test": ["lint"]},
+		"bin": {"lint": "/nix/store/abc-lint/bin/lint"}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &RunSpec{Version: runSpecVersion, Tasks: map[string]*RunSpecTask{
+		"lint": {After: []string{}, Bin: "/nix/store/abc-lint/bin/lint"},
+		"test": {After: []string{"lint"}},
+	}}
+	if !reflect.DeepEqual(spec, expected) {
+		t.Errorf("expected %+v, got %+v", expected, spec)
+	}
+}
+
+func TestParseRunSpecErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		spec string
+		err  string
+	}{
+		{
+			name: "unknown field",
+			spec: `{"version": 1, "tasks": {"a": {"after": []}}, "dag": {}}`,
+			err:  `unknown field "dag"`,
+		},
+		{
+			name: "unknown task field",
+			spec: `{"version": 1, "tasks": {"a": {"after": [], "command": "lint"}}}`,
+			err:  `unknown field "command"`,
+		},
+		{
+			name: "unknown unversioned field",
+			spec: `{"dag": {"a": []}, "bin": {}, "tasks": {}}`,
+			err:  `unknown field "tasks"`,
+		},
+		{
+			name: "bin of unknown task",
+			spec: `{"dag": {"a": []}, "bin": {"b": "/bin/true"}}`,
+			err:  `bin for unknown task "b"`,
+		},
+		{
+			name: "version 0",
+			spec: `{"version": 0, "tasks": {"a": {"after": []}}}`,
+			err:  "unsupported run spec version 0, expected 1",
+		},
+		{
+			name: "newer version",
+			spec: `{"version": 2, "tasks": {"a": {"after": []}}}`,
+			err:  "unsupported run spec version 2, expected 1",
+		},
+		{
+			name: "no tasks",
+			spec: `{"version": 1, "tasks": {}}`,
+			err:  "no tasks",
+		},
+		{
+			name: "invalid timeout",
+			spec: `{"version": 1, "tasks": {"a": {"after": [], "timeout": "5 minutes"}}}`,
+			err:  `task "a" has an invalid timeout`,
+		},
+		{
+			name: "negative timeout",
+			spec: `{"version": 1, "tasks": {"a": {"after": [], "timeout": "-1m"}}}`,
+			err:  `task "a" has a timeout that isn't positive`,
+		},
+		{
+			name: "unknown runtime",
+			spec: `{"version": 1, "tasks": {"a": {"after": [], "runtime": "docker"}}}`,
+			err:  `unknown runtime "docker"`,
+		},
+		{
+			name: "trailing data",
+			spec: `{"version": 1, "tasks": {"a": {"after": []}}} {}`,
+			err:  "decoding run spec",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseRunSpec([]byte(tc.spec))
+			if err == nil {
+				t.Fatalf("expected an error containing %q", tc.err)
+			}
+			if !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("expected an error containing %q, got %q", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestRunSpecEnvInPassthrough(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "task.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho \"$FOO\" > \"$OUT\"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	spec := fmt.Sprintf(`{"version": 1, "tasks": {
+		"a": {"after": [], "bin": %[1]q, "env": {"FOO": "from a", "OUT": %[2]q}},
+		"top": {"after": ["a"], "bin": %[1]q, "env": {"FOO": "from top", "OUT": %[3]q}}
+	}}`, script, filepath.Join(dir, "a.out"), filepath.Join(dir, "top.out"))
+
+	tree := newTestTree(t, Run{Task: "top", Mode: "passthrough"}, spec)
+	if err := tree.start(); err != nil {
+		t.Fatalf("running tasks: %s", err)
+	}
+
+	for name, expected := range map[string]string{"a": "from a", "top": "from top"} {
+		out, err := os.ReadFile(filepath.Join(dir, name+".out"))
+		if err != nil {
+			t.Fatalf("reading output of %s: %s", name, err)
+		}
+		if got := strings.TrimSpace(string(out)); got != expected {
+			t.Errorf("expected %s to see FOO=%q, got %q", name, expected, got)
+		}
+	}
+}
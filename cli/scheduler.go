@@ -0,0 +1,318 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// taskResult is sent to the scheduler once a build or run of a task finished.
+type taskResult struct {
+	task  *Task
+	stage string
+	err   error
+}
+
+// control is a command sent to the scheduler while a run is in progress.
+type control struct {
+	action string
+	task   string
+}
+
+const (
+	controlKill    = "kill"
+	controlRestart = "restart"
+	controlSkip    = "skip"
+	// hold keeps the scheduler running after all tasks finished,
+	// so tasks can still be restarted, until it is released.
+	controlHold    = "hold"
+	controlRelease = "release"
+)
+
+// prepare selects the given task and all of its transitive dependencies to run.
+func (t *Tree) prepare(taskName string) error {
+	root, err := t.task(taskName)
+	if err != nil {
+		return err
+	}
+
+	t.selected = map[string]bool{}
+	var selectTask func(*Task)
+	selectTask = func(task *Task) {
+		if t.selected[task.name] {
+			return
+		}
+		t.selected[task.name] = true
+		for _, predecessor := range task.predecessors {
+			selectTask(predecessor.Value.(*Task))
+		}
+	}
+	selectTask(root)
+
+	t.run = []*Task{}
+	for _, task := range t.allTasks() {
+		if t.selected[task.name] {
+			t.run = append(t.run, task)
+		}
+	}
+	t.assignPrefixes()
+
+	return nil
+}
+
+// control sends a command to the scheduler. It never blocks.
+func (t *Tree) control(action, taskName string) {
+	t.controls.push(control{action: action, task: taskName})
+}
+
+// controlQueue holds commands until the scheduler gets to them.
+// It is unbounded, so sending never blocks and no command is lost.
+type controlQueue struct {
+	mutex   sync.Mutex
+	pending []control
+	// ready has a value while commands are pending
+	ready chan struct{}
+}
+
+func newControlQueue() *controlQueue {
+	return &controlQueue{ready: make(chan struct{}, 1)}
+}
+
+func (q *controlQueue) push(c control) {
+	q.mutex.Lock()
+	q.pending = append(q.pending, c)
+	q.mutex.Unlock()
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// take returns all pending commands in the order they were sent.
+func (q *controlQueue) take() []control {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	pending := q.pending
+	q.pending = nil
+	return pending
+}
+
+// selectedTasks returns the tasks chosen by prepare, ordered by name.
+func (t *Tree) selectedTasks() []*Task {
+	return t.run
+}
+
+// start builds all selected tasks in parallel and runs each of them
+// once its dependencies are done, until all of them finished.
+func (t *Tree) start() error {
+	if t.selected == nil {
+		t.config.log.Fatal().Msg("start was called before prepare")
+	}
+
+	t.started = time.Now()
+	tasks := t.selectedTasks()
+
+	for _, task := range tasks {
+		if bin := task.prebuilt(); bin == "" {
+			task.update(func(s *taskState) { s.stage = "wait" })
+			t.startBuild(task)
+		} else {
+			task.storePath = bin
+			task.built = true
+			task.update(func(s *taskState) {
+				s.stage = "wait"
+				s.waitStart = t.started
+			})
+		}
+	}
+
+	for {
+		t.schedule(tasks)
+		if !t.held && t.idle(tasks) {
+			break
+		}
+
+		select {
+		case result := <-t.results:
+			t.handleResult(result)
+		case <-t.controls.ready:
+			for _, c := range t.controls.take() {
+				t.handleControl(c)
+			}
+		}
+	}
+
+	t.finished = time.Now()
+
+	for _, task := range tasks {
+		if err := task.snapshot().err; err != nil {
+			return errors.WithMessagef(err, "running %s", task.name)
+		}
+	}
+	for _, task := range tasks {
+		if err := task.snapshot().dependencyErr; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *Tree) startBuild(task *Task) {
+	task.busy = true
+	go func() {
+		t.results <- taskResult{task: task, stage: "build", err: task.build()}
+	}()
+}
+
+func (t *Tree) startRun(task *Task) {
+	task.busy = true
+	go func() {
+		t.results <- taskResult{task: task, stage: "run", err: task.run()}
+	}()
+}
+
+func (t *Tree) handleResult(result taskResult) {
+	task := result.task
+	task.busy = false
+
+	if result.stage == "run" && result.err != nil && task.retry() {
+		return
+	}
+
+	if task.fail(result.err) {
+		return
+	}
+
+	if result.stage == "build" {
+		task.built = true
+		task.update(func(s *taskState) { s.waitStart = time.Now() })
+	}
+}
+
+// schedule starts all tasks that are built and whose dependencies are done,
+// and cancels those with a failed dependency.
+func (t *Tree) schedule(tasks []*Task) {
+	for changed := true; changed; {
+		changed = false
+
+		for _, task := range tasks {
+			if task.busy || !task.built || task.snapshot().stage != "wait" {
+				continue
+			}
+
+			ready, canceled := true, false
+			for _, vert := range task.predecessors {
+				predecessor := vert.Value.(*Task).snapshot()
+				switch predecessor.stage {
+				case "done", "skip":
+				case "error":
+					task.cancel(predecessor.err)
+					canceled = true
+				case "cancel":
+					task.cancel(predecessor.dependencyErr)
+					canceled = true
+				default:
+					ready = false
+				}
+				if canceled {
+					changed = true
+					break
+				}
+			}
+
+			if ready && !canceled {
+				t.startRun(task)
+			}
+		}
+	}
+}
+
+// idle is true if no task is active or could still become active.
+func (t *Tree) idle(tasks []*Task) bool {
+	for _, task := range tasks {
+		if task.busy {
+			return false
+		}
+		switch task.snapshot().stage {
+		case "done", "error", "cancel", "skip":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (t *Tree) handleControl(c control) {
+	switch c.action {
+	case controlHold:
+		t.held = true
+		return
+	case controlRelease:
+		t.held = false
+		return
+	}
+
+	if !t.selected[c.task] {
+		return
+	}
+
+	task, err := t.task(c.task)
+	if err != nil {
+		return
+	}
+
+	switch c.action {
+	case controlKill:
+		task.kill()
+	case controlRestart:
+		if stage := task.snapshot().stage; task.busy || (stage != "error" && stage != "cancel" && stage != "skip") {
+			return
+		}
+		t.reset(task)
+		if !task.built {
+			t.startBuild(task)
+		}
+	case controlSkip:
+		if task.busy || task.snapshot().stage == "done" {
+			return
+		}
+		task.update(func(s *taskState) {
+			s.stage = "skip"
+			s.err = nil
+			s.dependencyErr = nil
+		})
+		t.resumeDependents(task)
+	}
+}
+
+// reset makes a failed, canceled or skipped task wait to run again.
+func (t *Tree) reset(task *Task) {
+	task.killed = false
+	task.update(func(s *taskState) {
+		s.stage = "wait"
+		s.err = nil
+		s.dependencyErr = nil
+		s.timedOut = false
+		s.retries = 0
+		s.runStart, s.runEnd = time.Time{}, time.Time{}
+		if !task.built {
+			s.evalStart, s.evalEnd = time.Time{}, time.Time{}
+			s.buildStart, s.buildEnd = time.Time{}, time.Time{}
+		}
+	})
+	t.resumeDependents(task)
+}
+
+// resumeDependents lets all dependents that were canceled because of this task wait again.
+func (t *Tree) resumeDependents(task *Task) {
+	for _, vert := range task.successors {
+		successor := vert.Value.(*Task)
+		if t.selected[successor.name] && successor.snapshot().stage == "cancel" {
+			t.reset(successor)
+		}
+	}
+}
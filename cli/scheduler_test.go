@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestControlsAreNeverDropped(t *testing.T) {
+	tree := newTestTree(t, Run{Task: "a"}, `{"version": 1, "tasks": {"a": {"after": [], "bin": "/bin/true"}}}`)
+
+	// more commands than the scheduler could ever have buffered
+	tree.control(controlHold, "")
+	for i := 0; i < 100; i++ {
+		tree.control(controlSkip, "unknown")
+	}
+	tree.control(controlRelease, "")
+
+	done := make(chan error, 1)
+	go func() { done <- tree.start() }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("running tasks: %s", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the scheduler is still held, the release was lost")
+	}
+}
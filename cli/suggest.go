@@ -0,0 +1,62 @@
+package main
+
+import "sort"
+
+// editDistance is the number of single character edits needed to turn a into b,
+// counting two swapped neighbouring characters as a single edit, as typos go.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(min(d[i-1][j]+1, d[i][j-1]+1), d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+
+	return d[len(ra)][len(rb)]
+}
+
+// suggest returns the candidates closest to name, best first,
+// leaving out those that are too different to be a likely typo.
+func suggest(name string, candidates []string, limit int) []string {
+	type match struct {
+		name     string
+		distance int
+	}
+
+	matches := []match{}
+	for _, candidate := range candidates {
+		d := editDistance(name, candidate)
+		if d <= max(1, (len([]rune(name))+2)/3) {
+			matches = append(matches, match{candidate, d})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].distance != matches[j].distance {
+			return matches[i].distance < matches[j].distance
+		}
+		return matches[i].name < matches[j].name
+	})
+
+	suggestions := []string{}
+	for i := 0; i < len(matches) && i < limit; i++ {
+		suggestions = append(suggestions, matches[i].name)
+	}
+	return suggestions
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	for _, tc := range []struct {
+		a, b     string
+		expected int
+	}{
+		{"build", "build", 0},
+		{"", "lint", 4},
+		{"biuld", "build", 1}, // transposition
+		{"tdiy", "tidy", 1},
+		{"Build", "build", 1}, // case matters
+		{"lint", "list", 1},
+		{"bump", "build", 3},
+		{"äb", "ba", 2},
+	} {
+		if d := editDistance(tc.a, tc.b); d != tc.expected {
+			t.Errorf("expected distance %d between %q and %q, got %d", tc.expected, tc.a, tc.b, d)
+		}
+		if d := editDistance(tc.b, tc.a); d != tc.expected {
+			t.Errorf("expected distance %d between %q and %q, got %d", tc.expected, tc.b, tc.a, d)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	tasks := []string{"build", "bump", "lint", "list", "tidy", "deploy-staging"}
+
+	for _, tc := range []struct {
+		name     string
+		expected []string
+	}{
+		{"biuld", []string{"build"}},
+		{"Build", []string{"build"}},
+		{"lnt", []string{"lint"}},
+		{"lisst", []string{"list", "lint"}},
+		{"deploy-stagign", []string{"deploy-staging"}},
+		// one edit is always allowed, even for short names
+		{"bu", []string{}},
+		{"x", []string{}},
+		// too different to be a typo
+		{"format", []string{}},
+		{"deploy", []string{}},
+	} {
+		if got := suggest(tc.name, tasks, 3); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("expected suggestions %v for %q, got %v", tc.expected, tc.name, got)
+		}
+	}
+
+	if got := suggest("l", []string{"a", "b", "c", "d"}, 2); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("expected the suggestions to be limited, got %v", got)
+	}
+}
+
+func TestTaskNotFoundError(t *testing.T) {
+	err := &taskNotFoundError{name: "biuld", tasks: []string{"build", "bump", "lint", "tidy"}}
+
+	expected := "Unknown task \"biuld\", did you mean \"build\"?\nAvailable tasks:\n  build  bump   lint   tidy"
+	if err.Error() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, err.Error())
+	}
+	if narrow := err.describe(20); !strings.HasSuffix(narrow, "Available tasks:\n  build  lint\n  bump   tidy") {
+		t.Errorf("expected two columns, got\n%s", narrow)
+	}
+}
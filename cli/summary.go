@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// resourceUsage of a command, as reported by the kernel once it exited.
+// For builds this only covers the nix client, not the builders of the daemon.
+type resourceUsage struct {
+	CPU    time.Duration
+	MaxRSS int64
+}
+
+func usageOf(state *os.ProcessState) resourceUsage {
+	if state == nil {
+		return resourceUsage{}
+	}
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return resourceUsage{}
+	}
+
+	maxRSS := int64(rusage.Maxrss)
+	if runtime.GOOS != "darwin" {
+		// Linux and the BSDs report kilobytes, macOS reports bytes.
+		maxRSS *= 1024
+	}
+
+	return resourceUsage{
+		CPU:    time.Duration(rusage.Utime.Nano() + rusage.Stime.Nano()),
+		MaxRSS: maxRSS,
+	}
+}
+
+// buildDetector notices nix announcing derivations it has to build,
+// which means the result of a build was neither in the store nor in a cache.
+type buildDetector struct {
+	out   io.Writer
+	tail  []byte
+	found bool
+}
+
+var willBeBuilt = []byte("will be built")
+
+func (d *buildDetector) Write(p []byte) (int, error) {
+	if !d.found {
+		// keep the end of the last write, the message may be split across writes
+		buf := append(d.tail, p...)
+		d.found = bytes.Contains(buf, willBeBuilt)
+		d.tail = append([]byte{}, buf[max(0, len(buf)-len(willBeBuilt)+1):]...)
+	}
+	if d.out == nil {
+		return len(p), nil
+	}
+	return d.out.Write(p)
+}
+
+type runSummary struct {
+	Target          string        `json:"target"`
+	Outcome         string        `json:"outcome"`
+	Tasks           []taskSummary `json:"tasks"`
+	Totals          summaryTotals `json:"totals"`
+	WallTime        time.Duration `json:"-"`
+	WallTimeSeconds float64       `json:"wallTimeSeconds"`
+}
+
+type taskSummary struct {
+	Name         string        `json:"name"`
+	Status       string        `json:"status"`
+	Cached       bool          `json:"cached"`
+	Skipped      bool          `json:"skipped"`
+	Build        time.Duration `json:"-"`
+	BuildSeconds float64       `json:"buildSeconds"`
+	Run          time.Duration `json:"-"`
+	RunSeconds   float64       `json:"runSeconds"`
+	CPU          time.Duration `json:"-"`
+	CPUSeconds   float64       `json:"cpuSeconds"`
+	MaxRSS       int64         `json:"maxRssBytes"`
+	Retries      int           `json:"retries"`
+	Error        string        `json:"error,omitempty"`
+}
+
+type summaryTotals struct {
+	Done         int           `json:"done"`
+	Failed       int           `json:"failed"`
+	Canceled     int           `json:"canceled"`
+	Skipped      int           `json:"skipped"`
+	Cached       int           `json:"cached"`
+	Build        time.Duration `json:"-"`
+	BuildSeconds float64       `json:"buildSeconds"`
+	Run          time.Duration `json:"-"`
+	RunSeconds   float64       `json:"runSeconds"`
+	CPU          time.Duration `json:"-"`
+	CPUSeconds   float64       `json:"cpuSeconds"`
+}
+
+// summary describes the outcome of every task of a finished run.
+func (t *Tree) summary(target string, err error) runSummary {
+	s := runSummary{
+		Target:   target,
+		Outcome:  runOutcomeSuccess,
+		Tasks:    []taskSummary{},
+		WallTime: spanDuration(t.started, t.finished),
+	}
+	if err != nil {
+		s.Outcome = runOutcomeFailure
+	}
+
+	for _, task := range t.tasks() {
+		state := task.snapshot()
+		ts := taskSummary{
+			Name:    task.name,
+			Status:  state.stage,
+			Cached:  state.cached,
+			Skipped: state.stage == "skip",
+			Build:   state.buildDuration(),
+			Run:     state.runDuration(),
+			CPU:     state.buildUsage.CPU + state.runUsage.CPU,
+			MaxRSS:  state.buildUsage.MaxRSS,
+			Retries: state.retries,
+		}
+		if state.runUsage.MaxRSS > ts.MaxRSS {
+			ts.MaxRSS = state.runUsage.MaxRSS
+		}
+		if state.err != nil {
+			ts.Error = state.err.Error()
+		}
+		ts.BuildSeconds = ts.Build.Seconds()
+		ts.RunSeconds = ts.Run.Seconds()
+		ts.CPUSeconds = ts.CPU.Seconds()
+		s.Tasks = append(s.Tasks, ts)
+
+		switch state.stage {
+		case "done":
+			s.Totals.Done++
+		case "error":
+			s.Totals.Failed++
+		case "cancel":
+			s.Totals.Canceled++
+		case "skip":
+			s.Totals.Skipped++
+		}
+		if ts.Cached {
+			s.Totals.Cached++
+		}
+		s.Totals.Build += ts.Build
+		s.Totals.Run += ts.Run
+		s.Totals.CPU += ts.CPU
+	}
+
+	s.Totals.BuildSeconds = s.Totals.Build.Seconds()
+	s.Totals.RunSeconds = s.Totals.Run.Seconds()
+	s.Totals.CPUSeconds = s.Totals.CPU.Seconds()
+	s.WallTimeSeconds = s.WallTime.Seconds()
+
+	return s
+}
+
+func (s runSummary) writeText(w io.Writer) {
+	nameLen := len("TASK")
+	for _, task := range s.Tasks {
+		nameLen = max(nameLen, len(task.Name))
+	}
+
+	fmt.Fprintf(w, "%-*s  %-6s  %-7s  %10s  %10s  %10s  %8s\n",
+		nameLen, "TASK", "STATUS", "", "BUILD", "RUN", "CPU", "MAX RSS")
+	for _, task := range s.Tasks {
+		flag := ""
+		switch {
+		case task.Skipped:
+			flag = "skipped"
+		case task.Cached:
+			flag = "cached"
+		}
+
+		fmt.Fprintf(w, "%-*s  %-6s  %-7s  %10s  %10s  %10s  %8s\n",
+			nameLen, task.Name, task.Status, flag,
+			summaryDuration(task.Build), summaryDuration(task.Run),
+			summaryDuration(task.CPU), summaryBytes(task.MaxRSS))
+	}
+
+	counts := []string{fmt.Sprintf("%d done", s.Totals.Done)}
+	for _, count := range []struct {
+		n    int
+		name string
+	}{
+		{s.Totals.Failed, "failed"},
+		{s.Totals.Canceled, "canceled"},
+		{s.Totals.Skipped, "skipped"},
+		{s.Totals.Cached, "cached"},
+	} {
+		if count.n > 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", count.n, count.name))
+		}
+	}
+
+	fmt.Fprintf(w, "%s of %s: %s, build %s, run %s, CPU %s, wall time %s\n",
+		s.Outcome, s.Target, strings.Join(counts, ", "),
+		summaryDuration(s.Totals.Build), summaryDuration(s.Totals.Run),
+		summaryDuration(s.Totals.CPU), summaryDuration(s.WallTime))
+}
+
+func summaryDuration(d time.Duration) string {
+	if d == 0 {
+		return "-"
+	}
+	return d.Round(time.Millisecond).String()
+}
+
+func summaryBytes(n int64) string {
+	if n == 0 {
+		return "-"
+	}
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// writeSummary prints the summary of the run to stderr unless in passthrough
+// mode, or writes it as JSON to the summary file, which defaults to the
+// directory of the run's logs.
+func (s *Supervisor) writeSummary(runLog *runLog, err error) error {
+	if s.config.Run.Summary == "none" {
+		return nil
+	}
+
+	summary := s.tree.summary(s.config.Run.Task, err)
+
+	if s.config.Run.Summary == "text" {
+		switch s.config.Run.Mode {
+		case "json":
+			s.tree.log.Log().Str("level", "info").Interface("summary", summary).Msg("summary")
+		case "passthrough":
+			// The output belongs to the task, which is usually a wrapped task
+			// running its dependencies within another run that has its own summary.
+		default:
+			fmt.Fprintln(os.Stderr)
+			summary.writeText(os.Stderr)
+		}
+		return nil
+	}
+
+	path := s.config.Run.SummaryFile
+	if path == "" && runLog != nil {
+		path = filepath.Join(runLog.dir, "summary.json")
+	}
+
+	out := io.Writer(os.Stdout)
+	if path != "" && path != "-" {
+		file, err := os.Create(path)
+		if err != nil {
+			return errors.WithMessage(err, "creating summary file")
+		}
+		defer file.Close()
+		out = file
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(summary)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSummaryText(t *testing.T) {
+	summary := runSummary{
+		Target:  "build",
+		Outcome: runOutcomeFailure,
+		Tasks: []taskSummary{
+			{Name: "lint", Status: "done", Cached: true, Build: 1210 * time.Millisecond, Run: 10892 * time.Millisecond, CPU: 8310 * time.Millisecond, MaxRSS: 312 << 20},
+			{Name: "bump", Status: "skip", Skipped: true},
+			{Name: "build", Status: "error", Build: 37102 * time.Millisecond, Run: 953 * time.Millisecond, CPU: 701 * time.Millisecond, MaxRSS: 48 << 20},
+		},
+		Totals: summaryTotals{
+			Done: 1, Failed: 1, Skipped: 1, Cached: 1,
+			Build: 38312 * time.Millisecond, Run: 11845 * time.Millisecond, CPU: 9011 * time.Millisecond,
+		},
+		WallTime: 49157 * time.Millisecond,
+	}
+
+	out := &bytes.Buffer{}
+	summary.writeText(out)
+
+	expected := `TASK   STATUS                BUILD         RUN         CPU   MAX RSS
+lint   done    cached        1.21s     10.892s       8.31s  312.0MiB
+bump   skip    skipped           -           -           -         -
+build  error               37.102s       953ms       701ms   48.0MiB
+failure of build: 1 done, 1 failed, 1 skipped, 1 cached, build 38.312s, run 11.845s, CPU 9.011s, wall time 49.157s
+`
+	if out.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out.String())
+	}
+}
+
+func TestBuildDetector(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		writes []string
+		found  bool
+	}{
+		{"nothing to build", []string{"copying path '/nix/store/abc-hello' from 'https://cache.nixos.org'...\n"}, false},
+		{"build", []string{"these 2 derivations will be built:\n", "  /nix/store/abc-hello.drv\n"}, true},
+		{"split across writes", []string{"this derivation will b", "e built:\n"}, true},
+		{"split in many writes", []string{"will", " ", "be", " bu", "ilt"}, true},
+		{"nearly", []string{"will be buil", "d"}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			d := &buildDetector{out: out}
+			for _, w := range tc.writes {
+				if n, err := d.Write([]byte(w)); err != nil || n != len(w) {
+					t.Fatalf("writing %q: %d, %v", w, n, err)
+				}
+			}
+			if d.found != tc.found {
+				t.Errorf("expected found to be %t", tc.found)
+			}
+			if out.String() != strings.Join(tc.writes, "") {
+				t.Errorf("expected the output to pass through, got %q", out.String())
+			}
+		})
+	}
+}
+
+// captureStderr returns what f wrote to stderr.
+func captureStderr(t *testing.T, f func()) string {
+	t.Helper()
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	stderr := os.Stderr
+	os.Stderr = file
+	defer func() { os.Stderr = stderr }()
+	f()
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestNoSummaryInPassthrough(t *testing.T) {
+	spec := `{"version": 1, "tasks": {"a": {"after": [], "bin": "/bin/true"}}}`
+
+	for mode, printed := range map[string]bool{"passthrough": false, "verbose": true} {
+		tree := newTestTree(t, Run{Task: "a", Mode: mode, Summary: "text"}, spec)
+		s := &Supervisor{tree: tree, config: tree.config}
+
+		out := captureStderr(t, func() {
+			if err := s.writeSummary(nil, nil); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if got := strings.Contains(out, "TASK"); got != printed {
+			t.Errorf("expected the summary to be printed in %s mode: %t, got %q", mode, printed, out)
+		}
+	}
+}
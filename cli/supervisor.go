@@ -25,7 +25,7 @@ func supervisor(config Config) (*Supervisor, error) {
 			Logger()
 	} else {
 		log = zerolog.
-			New(zerolog.NewConsoleWriter()).
+			New(zerolog.NewConsoleWriter(func(w *zerolog.ConsoleWriter) { w.NoColor = !config.Run.color })).
 			With().
 			Timestamp().
 			Logger()
@@ -39,14 +39,112 @@ func supervisor(config Config) (*Supervisor, error) {
 }
 
 func (s *Supervisor) start() error {
+	switch s.config.Run.Report {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("Unknown report format: %q", s.config.Run.Report)
+	}
+	switch s.config.Run.Summary {
+	case "text", "json", "none":
+	default:
+		return fmt.Errorf("Unknown summary format: %q", s.config.Run.Summary)
+	}
+	if _, err := s.tree.task(s.config.Run.Task); err != nil {
+		return err
+	}
+
+	runLog := s.startRunLog()
+
+	var err error
 	switch s.config.Run.Mode {
 	case "cli":
-		return s.startCLI()
+		err = s.startCLI()
 	case "verbose", "passthrough", "json":
-		return s.startCommon()
+		err = s.startCommon()
+	case "github":
+		err = s.startGitHub()
 	default:
 		return fmt.Errorf("Unknown mode: %q", s.config.Run.Mode)
 	}
+
+	if summaryErr := s.writeSummary(runLog, err); summaryErr != nil {
+		s.config.log.Error().Err(summaryErr).Msg("writing summary")
+	}
+
+	if runLog != nil {
+		s.finishRunLog(runLog, err)
+	}
+
+	if s.config.Run.Mode != "passthrough" {
+		runID := ""
+		if runLog != nil {
+			runID = runLog.ID
+		}
+		if historyErr := appendHistory(s.config.Run.StateDir, s.tree.historyEntry(runID, err)); historyErr != nil {
+			s.config.log.Warn().Err(historyErr).Msg("recording history")
+		}
+	}
+
+	if s.config.Run.Trace != "" {
+		if traceErr := s.tree.writeTrace(s.config.Run.Trace); traceErr != nil {
+			s.config.log.Error().Err(traceErr).Msg("writing trace")
+		}
+	}
+
+	if s.tree.otel != nil {
+		if otelErr := s.tree.exportOTel(); otelErr != nil {
+			s.config.log.Error().Err(otelErr).Msg("exporting OpenTelemetry trace")
+		}
+	}
+
+	if s.config.Run.Report != "" {
+		if reportErr := s.writeReport(); reportErr != nil {
+			s.config.log.Error().Err(reportErr).Msg("writing report")
+		}
+	}
+
+	return err
+}
+
+// startRunLog prepares persisting the output of tasks.
+// Passthrough mode hands the terminal to the tasks, so nothing is persisted.
+func (s *Supervisor) startRunLog() *runLog {
+	if s.config.Run.KeepRuns <= 0 || s.config.Run.Mode == "passthrough" {
+		return nil
+	}
+
+	runLog, err := newRunLog(s.config.Run.StateDir, s.config.Run.Task, s.config.Run.Mode)
+	if err != nil {
+		s.config.log.Warn().Err(err).Msg("not persisting logs")
+		return nil
+	}
+
+	for _, task := range s.tree.allTasks() {
+		task.runLog = runLog
+	}
+
+	return runLog
+}
+
+func (s *Supervisor) finishRunLog(runLog *runLog, err error) {
+	if err := runLog.finish(s.tree.tasks(), err); err != nil {
+		s.config.log.Warn().Err(err).Msg("saving run log")
+	}
+	if err := pruneRuns(s.config.Run.StateDir, s.config.Run.KeepRuns); err != nil {
+		s.config.log.Warn().Err(err).Msg("pruning old runs")
+	}
+
+	if s.config.Run.Mode == "json" {
+		s.tree.log.Info().Str("dir", runLog.dir).Str("run", runLog.ID).Msg("logs written")
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "Logs: %s\n", runLog.dir)
+	for _, task := range s.tree.tasks() {
+		if task.snapshot().err != nil && task.logFile != nil {
+			fmt.Fprintf(os.Stderr, "  %s failed, see %s\n", task.name, runLog.taskPath(task.name))
+		}
+	}
 }
 
 func (s *Supervisor) startCLI() error {
@@ -54,26 +152,75 @@ func (s *Supervisor) startCLI() error {
 		return err
 	}
 
+	estimates := map[string]*taskStats{}
+	if history, err := loadHistory(s.config.Run.StateDir); err != nil {
+		s.config.log.Warn().Err(err).Msg("reading history for estimates")
+	} else {
+		estimates = historyStats(history, 20)
+	}
+
+	if !isTerminal(os.Stdout) {
+		return s.startPlain(estimates)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	failed := make(chan error, 1)
 
+	model := newCLIModel(s.tree, ctx, s.config.log)
+	model.estimates = estimates
+
 	go func() {
 		err := s.tree.start()
+		s.tree.bus.close()
 		cancel()
 		failed <- err
 	}()
 
-	if err := tea.NewProgram(&CLIModel{tree: s.tree, ctx: ctx, log: s.config.log}).Start(); err != nil {
+	if err := tea.NewProgram(model).Start(); err != nil {
 		s.config.log.Fatal().Err(err).Msg("starting CLI")
 	}
 
 	return <-failed
 }
 
+// startPlain is the cli mode without a terminal to draw the interface on.
+func (s *Supervisor) startPlain(estimates map[string]*taskStats) error {
+	reporter := newPlainReporter(s.tree, os.Stdout, s.config.Run.StatusInterval)
+	reporter.estimates = estimates
+
+	events := s.tree.bus.subscribe()
+	reported := make(chan struct{})
+	go func() {
+		reporter.report(events)
+		close(reported)
+	}()
+
+	err := s.tree.start()
+	s.tree.bus.close()
+	<-reported
+
+	return err
+}
+
 func (s *Supervisor) startCommon() error {
 	if err := s.tree.prepare(s.config.Run.Task); err != nil {
 		return err
 	}
 
-	return s.tree.start()
+	reported := make(chan struct{})
+	if s.config.Run.Mode == "json" {
+		events := s.tree.bus.subscribe()
+		go func() {
+			reportJSON(s.tree.log, events)
+			close(reported)
+		}()
+	} else {
+		close(reported)
+	}
+
+	err := s.tree.start()
+	s.tree.bus.close()
+	<-reported
+
+	return err
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"os/signal"
@@ -19,21 +20,54 @@ import (
 type Task struct {
 	config        Config
 	name          string
-	stage         string
 	successors    []*dag.Vertex
 	predecessors  []*dag.Vertex
-	dependencies  *sync.WaitGroup
-	once          *sync.Once
+	built         bool
+	busy          bool
+	killed        bool
 	storePath     string
 	cmd           *exec.Cmd
+	log           zerolog.Logger
+	githubGroup   *githubGroup
+	drvPath       string
+	drvBuilds     []drvBuild
+	bus           *eventBus
+	traceID       traceID
+	spanID        spanID
+	runLog        *runLog
+	logFile       *taskLog
+	logStreams    []*taskLogStream
+	prefix        string
+	prefixWriters []*prefixWriter
+	captured      *bytes.Buffer
+	pty           *ptySession
+	spec          *RunSpecTask
+
+	// mutex guards the taskState, which is changed by the task's goroutine
+	// and the scheduler, and read by reporters while the task is running.
+	mutex sync.Mutex
+	taskState
+}
+
+// taskState is the part of a task that changes while it is running.
+type taskState struct {
+	stage         string
 	err           error
 	dependencyErr error
-	log           zerolog.Logger
-	cliLines      *bytes.Buffer
+	evalStart     time.Time
+	evalEnd       time.Time
 	buildStart    time.Time
 	buildEnd      time.Time
 	runStart      time.Time
 	runEnd        time.Time
+	waitStart     time.Time
+	exitCode      int
+	pgid          int
+	cached        bool
+	buildUsage    resourceUsage
+	runUsage      resourceUsage
+	timedOut      bool
+	retries       int
 }
 
 func newTask(log zerolog.Logger, config Config, taskName string) *Task {
@@ -42,60 +76,39 @@ func newTask(log zerolog.Logger, config Config, taskName string) *Task {
 		name:         taskName,
 		successors:   []*dag.Vertex{},
 		predecessors: []*dag.Vertex{},
-		dependencies: &sync.WaitGroup{},
-		once:         &sync.Once{},
 		config:       config,
 	}
 }
 
-func (t *Task) prepare(prepareWG, startWG *sync.WaitGroup) error {
-	t.once.Do(func() {
-		startWG.Add(1)
-		go func() {
-			defer startWG.Done()
-			prepareWG.Wait()
-			t.stage = "wait"
-
-			if t.config.Run.runSpec == nil {
-				if t.fail(t.build()) {
-					return
-				}
-			} else {
-				t.storePath = t.config.Run.runSpec.Bin[t.name]
-			}
+// update changes the state of the task and publishes the new state.
+func (t *Task) update(f func(*taskState)) {
+	t.mutex.Lock()
+	f(&t.taskState)
+	state := t.taskState
+	t.mutex.Unlock()
 
-			t.dependencies.Wait()
-			if t.dependencyFailed() {
-				return
-			}
-			if t.fail(t.err) {
-				return
-			}
-			if t.fail(t.run()) {
-				return
-			}
-			t.notifySuccessors(nil)
-		}()
-
-		for _, predecessor := range t.predecessors {
-			if t.fail(predecessor.Value.(*Task).prepare(prepareWG, startWG)) {
-				return
-			}
-		}
-	})
+	if t.bus != nil {
+		t.bus.publish(stateEvent{task: t.name, state: state})
+	}
+}
 
-	return nil
+// snapshot returns a consistent copy of the task's state.
+func (t *Task) snapshot() taskState {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.taskState
 }
 
 func (t *Task) preExec(stage string) {
-	t.stage = stage
-
-	switch stage {
-	case "build":
-		t.buildStart = time.Now()
-	case "run":
-		t.runStart = time.Now()
-	}
+	t.update(func(s *taskState) {
+		s.stage = stage
+		switch stage {
+		case "build":
+			s.buildStart = time.Now()
+		case "run":
+			s.runStart = time.Now()
+		}
+	})
 
 	switch t.config.Run.Mode {
 	case "json":
@@ -106,90 +119,109 @@ func (t *Task) preExec(stage string) {
 		t.preExecVerbose()
 	case "passthrough":
 		t.preExecPassthrough()
+	case "github":
+		t.preExecGitHub()
 	default:
 		t.config.log.Fatal().Str("mode", t.config.Run.Mode).Msg("unknown mode")
 	}
+
+	if t.runLog != nil {
+		t.teeLog(stage)
+	}
 }
 
 func (t *Task) preExecVerbose() {
 	t.log.Debug().Stringer("cmd", t.cmd).Msg("start")
-	log := t.log.
-		Output(zerolog.ConsoleWriter{Out: os.Stderr}).
-		With().
-		Str("level", zerolog.LevelInfoValue).
-		Timestamp()
-	if t.cmd.Stdout == nil {
-		t.cmd.Stdout = log.Str("std", "out").Logger()
-	}
-	if t.cmd.Stderr == nil {
-		t.cmd.Stderr = log.Str("std", "err").Logger()
-	}
+	t.prefixOutput()
 }
 
 func (t *Task) preExecCLI() {
-	t.cliLines = &bytes.Buffer{}
-	if t.cmd.Stdout == nil {
-		t.cmd.Stdout = t.cliLines
-	}
-	if t.cmd.Stderr == nil {
-		t.cmd.Stderr = t.cliLines
-	}
-}
-
-func (t *Task) preExecPassthrough() {
 	if t.cmd.Stdout == nil {
-		t.cmd.Stdout = os.Stdout
+		t.cmd.Stdout = &outputWriter{task: t, stream: "out"}
 	}
 	if t.cmd.Stderr == nil {
-		t.cmd.Stderr = os.Stderr
+		t.cmd.Stderr = &outputWriter{task: t, stream: "err"}
 	}
-	t.cmd.Stdin = os.Stdin
-	t.cmd.Env = os.Environ()
 }
 
 func (t *Task) preExecJSON() {
 	t.log.Debug().Stringer("cmd", t.cmd).Msg("start")
-	log := t.log.With().Str("level", zerolog.LevelDebugValue)
 	if t.cmd.Stdout == nil {
-		t.cmd.Stdout = log.Str("std", "out").Logger()
+		t.cmd.Stdout = &outputWriter{task: t, stream: "out"}
 	}
 	if t.cmd.Stderr == nil {
-		t.cmd.Stderr = log.Str("std", "err").Logger()
+		t.cmd.Stderr = &outputWriter{task: t, stream: "err"}
 	}
 }
 
 func (t *Task) exec(stage string, f func()) error {
-	t.cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if t.cmd.SysProcAttr == nil {
+		t.cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
 	err := t.cmd.Start()
+	if err == nil && t.pty != nil {
+		t.pty.start()
+	}
 	if err == nil {
 		var pgid int
 		pgid, err = syscall.Getpgid(t.cmd.Process.Pid)
 
 		if err == nil {
-			c := make(chan os.Signal)
+			t.update(func(s *taskState) { s.pgid = pgid })
+
+			c := make(chan os.Signal, 1)
 			go func() {
-				<-c
-				_ = syscall.Kill(-pgid, 15)
+				if _, ok := <-c; ok {
+					_ = syscall.Kill(-pgid, 15)
+				}
 			}()
 			signal.Notify(c, os.Kill, os.Interrupt)
 
-			// TODO: Measure resources here in cli mode
+			stopTimeout := func() {}
+			if stage == "done" {
+				stopTimeout = t.startTimeout(pgid)
+			}
+
 			err = t.cmd.Wait()
+
+			stopTimeout()
+
+			signal.Stop(c)
+			close(c)
 		}
 	}
 
-	switch t.stage {
-	case "build":
-		t.buildEnd = time.Now()
-	case "run":
-		t.runEnd = time.Now()
+	if t.pty != nil {
+		t.pty.stop()
+		t.pty = nil
 	}
+	t.flushPrefixed()
+	t.flushLog()
+
+	t.update(func(s *taskState) {
+		s.pgid = 0
+		switch s.stage {
+		case "build":
+			s.buildEnd = time.Now()
+			s.buildUsage = usageOf(t.cmd.ProcessState)
+		case "run":
+			s.runEnd = time.Now()
+			s.runUsage = usageOf(t.cmd.ProcessState)
+			if t.cmd.ProcessState != nil {
+				s.exitCode = t.cmd.ProcessState.ExitCode()
+			}
+		}
+	})
 
 	switch t.config.Run.Mode {
 	case "json":
 		return t.postExecJSON(stage, f, err)
-	case "cli", "verbose", "passthrough":
+	case "cli", "verbose":
 		return t.postExecCommon(stage, f, err)
+	case "passthrough":
+		return t.postExecPassthrough(stage, f, err)
+	case "github":
+		return t.postExecGitHub(stage, f, err)
 	default:
 		return fmt.Errorf("unknown mode %q", t.config.Run.Mode)
 	}
@@ -201,7 +233,7 @@ func (t *Task) postExecJSON(stage string, f func(), err error) error {
 		return errors.WithMessagef(err, "Failed to run %s", t.cmd)
 	} else {
 		t.log.Debug().Caller().Int("exit_status", t.cmd.ProcessState.ExitCode()).Msg("exited")
-		t.stage = stage
+		t.update(func(s *taskState) { s.stage = stage })
 		f()
 		return nil
 	}
@@ -216,39 +248,61 @@ func (t *Task) postExecCommon(stage string, f func(), err error) error {
 			return errors.WithMessagef(err, "Failed to run %s", t.cmd)
 		}
 	} else {
-		t.stage = stage
+		t.update(func(s *taskState) { s.stage = stage })
 		f()
 		return nil
 	}
 }
 
-func (t *Task) dependencyFailed() bool {
-	if t.dependencyErr == nil {
-		return false
-	}
-	t.stage = "cancel"
-	t.notifySuccessors(errors.WithMessagef(t.dependencyErr, "%q failed", t.name))
-	return true
+// cancel stops the task from running because a dependency failed.
+func (t *Task) cancel(dependencyErr error) {
+	t.update(func(s *taskState) {
+		s.stage = "cancel"
+		s.dependencyErr = errors.WithMessagef(dependencyErr, "%q failed", t.name)
+	})
 }
 
 func (t *Task) fail(err error) bool {
 	if err == nil {
 		return false
 	}
-	t.stage = "error"
-	t.err = err
-	t.notifySuccessors(errors.WithMessagef(err, "%q failed", t.name))
+	if t.killed {
+		err = errors.WithMessage(err, "killed")
+	}
+	if t.snapshot().timedOut {
+		err = errors.WithMessagef(err, "timed out after %s", t.spec.timeout)
+	}
+	t.update(func(s *taskState) {
+		s.stage = "error"
+		s.err = err
+	})
+	if t.config.Run.Mode == "github" {
+		t.annotateGitHub()
+	}
 	return true
 }
 
+// kill terminates the process group of the currently running command.
+func (t *Task) kill() bool {
+	pgid := t.snapshot().pgid
+	if pgid == 0 {
+		return false
+	}
+	t.killed = true
+	return syscall.Kill(-pgid, syscall.SIGTERM) == nil
+}
+
 func (t *Task) build() error {
+	t.update(func(s *taskState) {
+		s.stage = "eval"
+		s.evalStart = time.Now()
+	})
+
 	t.cmd = exec.Command("nix", "build", "--json", "--no-link")
 
 	stderr := &bytes.Buffer{}
 	t.cmd.Stdout = stderr
 
-	t.preExec("build")
-
 	// XXX Unfortunately, until https://github.com/NixOS/nix/pull/6333 is merged,
 	// we cannot build this with only one nix command due to escaping issues.
 	// The `nix build` command takes an "installable" argument,
@@ -271,14 +325,35 @@ func (t *Task) build() error {
 
 	if drv, err := exec.Command(
 		"nix", "eval", "--raw", t.config.Run.TaskFlake,
-		"--apply", "f: f."+nameNixStr+"."+t.config.Run.Runtime+".run.drvPath",
+		"--apply", "f: f."+nameNixStr+"."+t.runtime()+".run.drvPath",
 	).Output(); err != nil {
 		return err
 	} else {
-		t.cmd.Args = append(t.cmd.Args, string(drv))
+		t.drvPath = string(drv)
+		t.cmd.Args = append(t.cmd.Args, t.drvPath)
+	}
+
+	t.update(func(s *taskState) { s.evalEnd = time.Now() })
+
+	t.preExec("build")
+
+	var built func() bool
+	if t.config.Run.Trace != "" {
+		// The internal log format tells us when each derivation is built.
+		t.cmd.Args = append(t.cmd.Args, "--log-format", "internal-json")
+		nixLog := &nixLogWriter{task: t, out: t.cmd.Stderr}
+		t.cmd.Stderr = nixLog
+		built = nixLog.built
+	} else {
+		// Nix lists the derivations it has to build before building them.
+		builds := &buildDetector{out: t.cmd.Stderr}
+		t.cmd.Stderr = builds
+		built = func() bool { return builds.found }
 	}
 
 	return t.exec("wait", func() {
+		t.update(func(s *taskState) { s.cached = !built() })
+
 		res := []nixBuildResult{}
 		if err := json.Unmarshal(stderr.Bytes(), &res); err != nil {
 			t.log.Err(err).Str("stderr", stderr.String()).Msg("waiting for result")
@@ -288,7 +363,7 @@ func (t *Task) build() error {
 			"%s/bin/%s-%s",
 			res[0].Outputs.Out,
 			t.name,
-			t.config.Run.Runtime,
+			t.runtime(),
 		)
 	})
 }
@@ -304,16 +379,36 @@ type nixBuildResultOutput struct {
 
 func (t *Task) run() error {
 	t.cmd = exec.Command(t.storePath)
+	t.applySpec()
 	t.preExec("run")
+	if t.spanID.valid() {
+		// Allows nested invocations of tullia to continue our trace.
+		if t.cmd.Env == nil {
+			t.cmd.Env = os.Environ()
+		}
+		t.cmd.Env = append(t.cmd.Env, "TRACEPARENT="+traceParent(t.traceID, t.spanID))
+	}
 	return t.exec("done", func() {})
 }
 
-func (t *Task) notifySuccessors(err error) {
-	for _, successor := range t.successors {
-		s := successor.Value.(*Task)
-		if err != nil {
-			s.dependencyErr = err
+// syncWriter guards writers shared by the stdout and stderr of a command.
+type syncWriter struct {
+	mutex *sync.Mutex
+	w     io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.w.Write(p)
+}
+
+// startedAt returns the time the first stage of the task started.
+func (t taskState) startedAt() time.Time {
+	for _, start := range []time.Time{t.evalStart, t.buildStart, t.runStart} {
+		if !start.IsZero() {
+			return start
 		}
-		s.dependencies.Done()
 	}
+	return time.Time{}
 }
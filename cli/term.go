@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+	"golang.org/x/term"
+)
+
+// isTerminal is true if f is connected to an interactive terminal.
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// useColor decides whether output is colored and configures lipgloss to match.
+// An explicit setting wins over NO_COLOR, see https://no-color.org.
+func useColor(setting string) (bool, error) {
+	switch setting {
+	case "always":
+		lipgloss.SetColorProfile(termenv.TrueColor)
+		return true, nil
+	case "never":
+		lipgloss.SetColorProfile(termenv.Ascii)
+		return false, nil
+	case "auto", "":
+		if os.Getenv("NO_COLOR") != "" || !isTerminal(os.Stdout) {
+			lipgloss.SetColorProfile(termenv.Ascii)
+			return false, nil
+		}
+		return true, nil
+	default:
+		return false, fmt.Errorf("Unknown color setting: %q", setting)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// stageSpan is a period of time a task spent in one stage.
+type stageSpan struct {
+	name  string
+	start time.Time
+	end   time.Time
+}
+
+// stageSpans returns the completed stages of the task in chronological order.
+func (t taskState) stageSpans() []stageSpan {
+	spans := []stageSpan{}
+	add := func(name string, start, end time.Time) {
+		if !start.IsZero() && !end.IsZero() {
+			spans = append(spans, stageSpan{name: name, start: start, end: end})
+		}
+	}
+
+	add("eval", t.evalStart, t.evalEnd)
+	add("build", t.buildStart, t.buildEnd)
+	if t.runStart.IsZero() {
+		add("wait", t.waitStart, t.runEnd)
+	} else {
+		add("wait", t.waitStart, t.runStart)
+	}
+	add("run", t.runStart, t.runEnd)
+
+	return spans
+}
+
+// drvBuild is a derivation that nix built for a task.
+type drvBuild struct {
+	drvPath string
+	start   time.Time
+	end     time.Time
+}
+
+// nixLogWriter parses the output of `nix build --log-format internal-json`,
+// keeps track of derivation builds, and writes human readable messages to out.
+type nixLogWriter struct {
+	task       *Task
+	out        io.Writer
+	buf        bytes.Buffer
+	activities map[int]int
+	builds     int
+}
+
+type nixLogLine struct {
+	Action string        `json:"action"`
+	ID     int           `json:"id"`
+	Type   int           `json:"type"`
+	Msg    string        `json:"msg"`
+	Fields []interface{} `json:"fields"`
+}
+
+const (
+	nixActivityBuild      = 105
+	nixResultBuildLogLine = 101
+)
+
+func (w *nixLogWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for {
+		line, err := w.buf.ReadBytes('\n')
+		if err != nil {
+			// incomplete line, wait for more input
+			w.buf.Write(line)
+			return len(p), nil
+		}
+		if err := w.handle(line); err != nil {
+			return len(p), err
+		}
+	}
+}
+
+func (w *nixLogWriter) handle(line []byte) error {
+	const prefix = "@nix "
+	if !bytes.HasPrefix(line, []byte(prefix)) {
+		return w.print(string(line))
+	}
+
+	msg := nixLogLine{}
+	if err := json.Unmarshal(line[len(prefix):], &msg); err != nil {
+		return w.print(string(line))
+	}
+
+	switch msg.Action {
+	case "msg":
+		return w.print(msg.Msg + "\n")
+	case "start":
+		if msg.Type != nixActivityBuild || len(msg.Fields) == 0 {
+			return nil
+		}
+		if drvPath, ok := msg.Fields[0].(string); ok {
+			if w.activities == nil {
+				w.activities = map[int]int{}
+			}
+			w.activities[msg.ID] = len(w.task.drvBuilds)
+			w.task.drvBuilds = append(w.task.drvBuilds, drvBuild{drvPath: drvPath, start: time.Now()})
+			w.builds++
+		}
+	case "stop":
+		if i, ok := w.activities[msg.ID]; ok {
+			w.task.drvBuilds[i].end = time.Now()
+			delete(w.activities, msg.ID)
+		}
+	case "result":
+		if msg.Type == nixResultBuildLogLine && len(msg.Fields) > 0 {
+			if text, ok := msg.Fields[0].(string); ok {
+				return w.print(text + "\n")
+			}
+		}
+	}
+
+	return nil
+}
+
+// built tells whether nix started to build any derivation,
+// otherwise the result was already in the store or a cache.
+func (w *nixLogWriter) built() bool {
+	return w.builds > 0
+}
+
+func (w *nixLogWriter) print(s string) error {
+	if w.out == nil {
+		return nil
+	}
+	_, err := io.WriteString(w.out, s)
+	return err
+}
+
+// traceEvent is an event of the Chrome trace event format.
+// See https://docs.google.com/document/d/1CvAClvFfyA5R-PhYUmn5OOQtYMH4h6I0nSsKchNAySU
+type traceEvent struct {
+	Name string                 `json:"name"`
+	Cat  string                 `json:"cat,omitempty"`
+	Ph   string                 `json:"ph"`
+	Ts   int64                  `json:"ts"`
+	Dur  int64                  `json:"dur,omitempty"`
+	Pid  int                    `json:"pid"`
+	Tid  int                    `json:"tid"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+type traceFile struct {
+	TraceEvents     []traceEvent `json:"traceEvents"`
+	DisplayTimeUnit string       `json:"displayTimeUnit"`
+}
+
+// writeTrace writes the timeline of the run in the Chrome trace event format,
+// which can be opened with https://ui.perfetto.dev or chrome://tracing.
+func (t *Tree) writeTrace(path string) error {
+	tasks := t.tasks()
+
+	origin := t.evalStart
+	for _, task := range tasks {
+		for _, span := range task.snapshot().stageSpans() {
+			if origin.IsZero() || span.start.Before(origin) {
+				origin = span.start
+			}
+		}
+	}
+
+	micros := func(ts time.Time) int64 { return ts.Sub(origin).Microseconds() }
+	slice := func(tid int, cat, name string, start, end time.Time, args map[string]interface{}) traceEvent {
+		return traceEvent{
+			Name: name, Cat: cat, Ph: "X", Pid: 1, Tid: tid,
+			Ts: micros(start), Dur: end.Sub(start).Microseconds(), Args: args,
+		}
+	}
+	meta := func(tid int, name string, args map[string]interface{}) traceEvent {
+		return traceEvent{Name: name, Ph: "M", Pid: 1, Tid: tid, Args: args}
+	}
+
+	events := []traceEvent{
+		meta(0, "process_name", map[string]interface{}{"name": "tullia run " + t.config.Run.Task}),
+		meta(0, "thread_name", map[string]interface{}{"name": "tullia"}),
+		meta(0, "thread_sort_index", map[string]interface{}{"sort_index": 0}),
+	}
+
+	if !t.evalStart.IsZero() && !t.evalEnd.IsZero() {
+		events = append(events, slice(0, "eval", "eval dag", t.evalStart, t.evalEnd,
+			map[string]interface{}{"flake": t.config.Run.DagFlake}))
+	}
+
+	for i, task := range tasks {
+		tid := i + 1
+		events = append(events,
+			meta(tid, "thread_name", map[string]interface{}{"name": task.name}),
+			meta(tid, "thread_sort_index", map[string]interface{}{"sort_index": tid}),
+		)
+
+		state := task.snapshot()
+		for _, span := range state.stageSpans() {
+			args := map[string]interface{}{"task": task.name}
+			switch span.name {
+			case "build":
+				if task.drvPath != "" {
+					args["drvPath"] = task.drvPath
+				}
+				if task.storePath != "" {
+					args["storePath"] = task.storePath
+				}
+			case "run":
+				if state.err != nil {
+					args["error"] = state.err.Error()
+				}
+			}
+			events = append(events, slice(tid, "stage", span.name, span.start, span.end, args))
+		}
+
+		for _, build := range task.drvBuilds {
+			if build.end.IsZero() {
+				continue
+			}
+			name := strings.TrimSuffix(build.drvPath[strings.LastIndex(build.drvPath, "/")+1:], ".drv")
+			events = append(events, slice(tid, "drv", name, build.start, build.end,
+				map[string]interface{}{"drvPath": build.drvPath}))
+		}
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return errors.WithMessage(err, "creating trace file")
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	if err := json.NewEncoder(w).Encode(traceFile{TraceEvents: events, DisplayTimeUnit: "ms"}); err != nil {
+		return errors.WithMessage(err, "encoding trace")
+	}
+	return w.Flush()
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNixLogWriter(t *testing.T) {
+	const (
+		hello = "/nix/store/q3wx1gab2ysnk5nyvyyg56ana2v4r2ar-hello-2.12.1.drv"
+		task  = "/nix/store/8mzvz2kmwsmfb1y4l5v6bm2brdm1mf9f-task-build.drv"
+	)
+
+	for _, tc := range []struct {
+		name   string
+		lines  []string
+		drvs   []string // built derivations, in order of their start
+		ended  []bool
+		built  bool
+		output string
+	}{
+		{
+			name: "build",
+			lines: []string{
+				`@nix {"action":"msg","level":0,"msg":"these 2 derivations will be built:\n  ` + hello + `\n  ` + task + `"}`,
+				`@nix {"action":"start","fields":["` + hello + `","",1,1],"id":94557,"level":3,"parent":0,"text":"building '` + hello + `'","type":105}`,
+				`@nix {"action":"result","fields":["unpacking sources"],"id":94557,"type":101}`,
+				`@nix {"action":"stop","id":94557}`,
+				`@nix {"action":"start","fields":["` + task + `","",1,1],"id":94558,"level":3,"parent":0,"text":"building '` + task + `'","type":105}`,
+				`@nix {"action":"result","fields":["installing"],"id":94558,"type":101}`,
+				`@nix {"action":"stop","id":94558}`,
+			},
+			drvs:   []string{hello, task},
+			ended:  []bool{true, true},
+			built:  true,
+			output: "these 2 derivations will be built:\n  " + hello + "\n  " + task + "\nunpacking sources\ninstalling\n",
+		},
+		{
+			name: "substituted",
+			lines: []string{
+				`@nix {"action":"msg","level":0,"msg":"this path will be fetched (0.01 MiB download, 0.05 MiB unpacked):\n  /nix/store/x9b0n4s3zkyx1ghgzq7ij0z4s3kjf1h2-task-build"}`,
+				`@nix {"action":"start","fields":["/nix/store/x9b0n4s3zkyx1ghgzq7ij0z4s3kjf1h2-task-build","https://cache.nixos.org","local"],"id":1,"level":4,"parent":0,"text":"copying path","type":100}`,
+				`@nix {"action":"stop","id":1}`,
+			},
+			output: "this path will be fetched (0.01 MiB download, 0.05 MiB unpacked):\n  /nix/store/x9b0n4s3zkyx1ghgzq7ij0z4s3kjf1h2-task-build\n",
+		},
+		{
+			// mentions "will be built" only escaped in JSON, yet builds nothing
+			name: "escaped message",
+			lines: []string{
+				`@nix {"action":"msg","level":1,"msg":"warning: \"will be built\" is not a task"}`,
+			},
+			output: "warning: \"will be built\" is not a task\n",
+		},
+		{
+			name: "interrupted",
+			lines: []string{
+				`@nix {"action":"start","fields":["` + hello + `","",1,1],"id":7,"level":3,"parent":0,"text":"building","type":105}`,
+				`error: interrupted by the user`,
+			},
+			drvs:   []string{hello},
+			ended:  []bool{false},
+			built:  true,
+			output: "error: interrupted by the user\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			task := &Task{name: "build"}
+			w := &nixLogWriter{task: task, out: out}
+
+			// nix writes lines in arbitrary chunks
+			input := []byte{}
+			for _, line := range tc.lines {
+				input = append(input, line+"\n"...)
+			}
+			for len(input) > 0 {
+				n := min(7, len(input))
+				if _, err := w.Write(input[:n]); err != nil {
+					t.Fatal(err)
+				}
+				input = input[n:]
+			}
+
+			drvs, ended := []string{}, []bool{}
+			for _, build := range task.drvBuilds {
+				drvs = append(drvs, build.drvPath)
+				ended = append(ended, !build.end.IsZero())
+				if build.start.IsZero() || (!build.end.IsZero() && build.end.Before(build.start)) {
+					t.Errorf("invalid span of %s: %v - %v", build.drvPath, build.start, build.end)
+				}
+			}
+			if tc.drvs == nil {
+				tc.drvs, tc.ended = []string{}, []bool{}
+			}
+			if !reflect.DeepEqual(drvs, tc.drvs) || !reflect.DeepEqual(ended, tc.ended) {
+				t.Errorf("expected builds %v ended %v, got %v ended %v", tc.drvs, tc.ended, drvs, ended)
+			}
+			if w.built() != tc.built {
+				t.Errorf("expected built to be %t", tc.built)
+			}
+			if out.String() != tc.output {
+				t.Errorf("expected output %q, got %q", tc.output, out.String())
+			}
+		})
+	}
+}
+
+func TestStageSpans(t *testing.T) {
+	at := func(s int) time.Time { return time.Unix(1700000000+int64(s), 0) }
+
+	names := func(state taskState) []string {
+		names := []string{}
+		for _, span := range state.stageSpans() {
+			names = append(names, span.name)
+		}
+		return names
+	}
+
+	done := taskState{
+		evalStart: at(0), evalEnd: at(1),
+		buildStart: at(1), buildEnd: at(3),
+		waitStart: at(3), runStart: at(5), runEnd: at(9),
+	}
+	if got := names(done); !reflect.DeepEqual(got, []string{"eval", "build", "wait", "run"}) {
+		t.Errorf("unexpected stages %v", got)
+	}
+
+	// canceled while waiting for dependencies, so it never ran
+	canceled := taskState{buildStart: at(1), buildEnd: at(3), waitStart: at(3), runEnd: at(4)}
+	spans := canceled.stageSpans()
+	if got := names(canceled); !reflect.DeepEqual(got, []string{"build", "wait"}) {
+		t.Errorf("unexpected stages %v", got)
+	} else if !spans[1].end.Equal(at(4)) {
+		t.Errorf("expected waiting to end when canceled, got %v", spans[1].end)
+	}
+
+	// still building
+	if got := names(taskState{buildStart: at(1)}); len(got) != 0 {
+		t.Errorf("expected no completed stages, got %v", got)
+	}
+}
+
+func TestWriteTrace(t *testing.T) {
+	tree := newTestTree(t, Run{Task: "b"}, `{"version": 1, "tasks": {
+		"a": {"after": [], "bin": "/bin/true"},
+		"b": {"after": ["a"], "bin": "/bin/true"}
+	}}`)
+
+	origin := time.Now()
+	at := func(ms int) time.Time { return origin.Add(time.Duration(ms) * time.Millisecond) }
+	for i, task := range tree.selectedTasks() {
+		offset := i * 100
+		task.update(func(s *taskState) {
+			s.stage = "done"
+			s.buildStart, s.buildEnd = at(offset), at(offset+10)
+			s.waitStart, s.runStart, s.runEnd = at(offset+10), at(offset+20), at(offset+50)
+		})
+		task.drvBuilds = []drvBuild{
+			{drvPath: "/nix/store/abc-" + task.name + ".drv", start: at(offset + 1), end: at(offset + 9)},
+			{drvPath: "/nix/store/def-unfinished.drv", start: at(offset + 2)},
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "trace.json")
+	if err := tree.writeTrace(path); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trace := traceFile{}
+	if err := json.Unmarshal(content, &trace); err != nil {
+		t.Fatal(err)
+	}
+
+	slices := map[string]traceEvent{}
+	threads := map[int]string{}
+	for _, event := range trace.TraceEvents {
+		switch event.Ph {
+		case "X":
+			slices[threads[event.Tid]+" "+event.Name] = event
+		case "M":
+			if event.Name == "thread_name" {
+				threads[event.Tid] = event.Args["name"].(string)
+			}
+		}
+	}
+
+	for name, expected := range map[string]struct{ ts, dur int64 }{
+		"a build": {0, 10000},
+		"a wait":  {10000, 10000},
+		"a run":   {20000, 30000},
+		"a abc-a": {1000, 8000},
+		"b build": {100000, 10000},
+		"b run":   {120000, 30000},
+		"b abc-b": {101000, 8000},
+	} {
+		slice, ok := slices[name]
+		if !ok {
+			t.Errorf("no slice %q in %v", name, slices)
+			continue
+		}
+		if slice.Ts != expected.ts || slice.Dur != expected.dur {
+			t.Errorf("expected %q at %dµs for %dµs, got %dµs for %dµs", name, expected.ts, expected.dur, slice.Ts, slice.Dur)
+		}
+	}
+	for name := range slices {
+		if name == "a def-unfinished" || name == "b def-unfinished" {
+			t.Errorf("unfinished build %q shouldn't be in the trace", name)
+		}
+	}
+	if drv := slices["a abc-a"].Args["drvPath"]; drv != "/nix/store/abc-a.drv" {
+		t.Errorf("unexpected drvPath %v", drv)
+	}
+}
@@ -6,8 +6,7 @@ import (
 	"os"
 	"os/exec"
 	"sort"
-	"strings"
-	"sync"
+	"time"
 
 	"github.com/goombaio/dag"
 	"github.com/pkg/errors"
@@ -18,21 +17,37 @@ type Tree struct {
 	dagResult map[string][]string
 	dag       *dag.DAG
 	taskNames []string
-	prepareWG *sync.WaitGroup
-	startWG   *sync.WaitGroup
+	selected  map[string]bool
+	run       []*Task
+	bus       *eventBus
+	results   chan taskResult
+	controls  *controlQueue
+	held      bool
 	log       zerolog.Logger
 	config    Config
+	evalStart time.Time
+	evalEnd   time.Time
+	started   time.Time
+	finished  time.Time
+	otel      *otelTrace
 }
 
 func newTree(log zerolog.Logger, config Config) (*Tree, error) {
 	tree := &Tree{
-		log:     log,
-		startWG: &sync.WaitGroup{},
-		dag:     dag.NewDAG(),
-		config:  config,
+		log:      log,
+		dag:      dag.NewDAG(),
+		config:   config,
+		bus:      &eventBus{},
+		results:  make(chan taskResult),
+		controls: newControlQueue(),
+	}
+	if config.Run.OTelURL != "" || config.Run.OTelFile != "" {
+		tree.otel = newOTelTrace()
 	}
 	if err := tree.eval(); err != nil {
 		return tree, err
+	} else if err := taskGraph(tree.dagResult).check(); err != nil {
+		return tree, err
 	} else if err := tree.addVertices(); err != nil {
 		return tree, err
 	} else if err := tree.addEdges(); err != nil {
@@ -43,22 +58,38 @@ func newTree(log zerolog.Logger, config Config) (*Tree, error) {
 	return tree, nil
 }
 
-func (t *Tree) start() error {
-	if t.prepareWG == nil {
-		t.config.log.Fatal().Msg("start was called before prepare")
-	}
-	t.prepareWG.Done()
-	t.startWG.Wait()
-
-	for _, vert := range t.dag.SinkVertices() {
-		if task, ok := vert.Value.(*Task); !ok {
-			return fmt.Errorf("converting vertex of %q to task", vert.ID)
-		} else if task.err != nil {
-			return errors.WithMessagef(task.err, "running %s", vert.ID)
+// allTasks returns all tasks of the DAG, ordered by name.
+func (t *Tree) allTasks() []*Task {
+	tasks := []*Task{}
+	for _, taskName := range t.taskNames {
+		if vert, err := t.dag.GetVertex(taskName); err == nil {
+			tasks = append(tasks, vert.Value.(*Task))
 		}
 	}
+	return tasks
+}
 
-	return nil
+// task returns the task of the given name, or a taskNotFoundError.
+func (t *Tree) task(name string) (*Task, error) {
+	if err := taskGraph(t.dagResult).lookup(name); err != nil {
+		return nil, err
+	}
+	vert, err := t.dag.GetVertex(name)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to get vertex %q", name)
+	}
+	return vert.Value.(*Task), nil
+}
+
+// tasks returns all tasks that took part in the run, ordered by name.
+func (t *Tree) tasks() []*Task {
+	tasks := []*Task{}
+	for _, task := range t.allTasks() {
+		if task.snapshot().stage != "" {
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks
 }
 
 func parseDag(dagFlake string) (map[string][]string, error) {
@@ -81,14 +112,16 @@ func (t *Tree) eval() error {
 		if t.config.Run.Mode == "passthrough" {
 			t.dagResult = map[string][]string{t.config.Run.Task: {}}
 		} else {
-			dagResult, err := parseDag(t.config.Run.DagFlake)
+			t.evalStart = time.Now()
+			dagResult, err := cachedDag(t.config.Run.DagFlake, t.config.Run.StateDir, t.config.Run.Refresh)
 			if err != nil {
 				return err
 			}
+			t.evalEnd = time.Now()
 			t.dagResult = dagResult
 		}
 	} else {
-		t.dagResult = t.config.Run.runSpec.Dag
+		t.dagResult = t.config.Run.runSpec.dag()
 	}
 
 	return nil
@@ -98,6 +131,14 @@ func (t *Tree) addVertices() error {
 	for taskName := range t.dagResult {
 		t.taskNames = append(t.taskNames, taskName)
 		task := newTask(t.log, t.config, taskName)
+		task.bus = t.bus
+		if t.config.Run.runSpec != nil {
+			task.spec = t.config.Run.runSpec.Tasks[taskName]
+		}
+		if t.otel != nil {
+			task.traceID = t.otel.traceID
+			task.spanID = newSpanID()
+		}
 		if err := t.dag.AddVertex(dag.NewVertex(taskName, task)); err != nil {
 			return errors.WithMessagef(err, "Failed to add vertex %q", taskName)
 		}
@@ -141,27 +182,8 @@ func (t *Tree) populateRelations() error {
 				return errors.WithMessagef(err, "Failed to get predecessors of task %q", vert.ID)
 			}
 			task.predecessors = predecessors
-
-			task.dependencies.Add(len(vert.Parents.Values()))
 		}
 	}
 
 	return nil
 }
-
-func (t *Tree) prepare(taskName string) error {
-	t.prepareWG = &sync.WaitGroup{}
-	t.prepareWG.Add(1)
-
-	root, err := t.dag.GetVertex(taskName)
-	if err != nil {
-		if err.Error() == fmt.Sprintf("vertex %s not found in the graph", taskName) {
-			return fmt.Errorf("Available tasks: %s\n", strings.Join(t.taskNames, " "))
-		}
-		return errors.WithMessagef(err, "failed to get vertex %q", taskName)
-	}
-
-	return errors.WithMessagef(
-		root.Value.(*Task).prepare(t.prepareWG, t.startWG),
-		"failed to prepare %q", taskName)
-}
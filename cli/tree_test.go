@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+// newTestTree prepares a tree of the tasks of the given run spec,
+// so no evaluation or build with nix is needed.
+func newTestTree(t *testing.T, run Run, spec string) *Tree {
+	t.Helper()
+
+	runSpec, err := parseRunSpec([]byte(spec))
+	if err != nil {
+		t.Fatalf("parsing run spec: %s", err)
+	}
+	run.runSpec = runSpec
+	if run.Mode == "" {
+		run.Mode = "cli"
+	}
+	if run.Runtime == "" {
+		run.Runtime = "unwrapped"
+	}
+
+	tree, err := newTree(zerolog.Nop(), Config{Run: &run, log: zerolog.Nop()})
+	if err != nil {
+		t.Fatalf("creating tree: %s", err)
+	}
+	if err := tree.prepare(run.Task); err != nil {
+		t.Fatalf("preparing tree: %s", err)
+	}
+	return tree
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	problemError   = "error"
+	problemWarning = "warning"
+)
+
+// graphProblem is something wrong with the dependencies declared between tasks.
+type graphProblem struct {
+	Severity string   `json:"severity"`
+	Kind     string   `json:"kind"`
+	Task     string   `json:"task"`
+	Path     []string `json:"path,omitempty"`
+	Message  string   `json:"message"`
+}
+
+// validate finds all problems of the graph at once.
+// Errors make it impossible to run tasks, warnings are merely untidy.
+func (g taskGraph) validate() []graphProblem {
+	problems := g.errors()
+
+	// Redundancy is only well defined without cycles, including tasks running after themselves.
+	for _, problem := range problems {
+		if problem.Kind == "cycle" || problem.Kind == "self" {
+			return problems
+		}
+	}
+
+	for _, edge := range g.redundantEdges() {
+		problems = append(problems, graphProblem{
+			Severity: problemWarning,
+			Kind:     "redundant",
+			Task:     edge.task,
+			Path:     edge.via,
+			Message: fmt.Sprintf("%s runs after %s already through %s",
+				edge.task, edge.after, strings.Join(edge.via, " → ")),
+		})
+	}
+
+	return problems
+}
+
+// errors finds the problems that make it impossible to run tasks:
+// tasks running after themselves or unknown tasks, and cycles.
+func (g taskGraph) errors() []graphProblem {
+	problems := []graphProblem{}
+	names := g.names()
+
+	for _, name := range names {
+		for _, after := range g[name] {
+			if after == name {
+				problems = append(problems, graphProblem{
+					Severity: problemError,
+					Kind:     "self",
+					Task:     name,
+					Message:  fmt.Sprintf("%s runs after itself", name),
+				})
+				continue
+			}
+			if _, ok := g[after]; ok {
+				continue
+			}
+
+			message := fmt.Sprintf("%s runs after unknown task %q", name, after)
+			if suggestions := suggest(after, names, 3); len(suggestions) > 0 {
+				message += fmt.Sprintf(", did you mean %s?", quoteAll(suggestions, " or "))
+			}
+			problems = append(problems, graphProblem{
+				Severity: problemError,
+				Kind:     "unknown",
+				Task:     name,
+				Path:     []string{name, after},
+				Message:  message,
+			})
+		}
+	}
+
+	for _, cycle := range g.cycles() {
+		problems = append(problems, graphProblem{
+			Severity: problemError,
+			Kind:     "cycle",
+			Task:     cycle[0],
+			Path:     cycle,
+			Message:  fmt.Sprintf("cycle: %s", strings.Join(cycle, " → ")),
+		})
+	}
+
+	return problems
+}
+
+// cycles returns every cycle of tasks running after each other, each starting
+// and ending with the same task. Self-dependencies are left to validate.
+func (g taskGraph) cycles() [][]string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := map[string]int{}
+	stack := []string{}
+	seen := map[string]bool{}
+	cycles := [][]string{}
+
+	var visit func(string)
+	visit = func(name string) {
+		state[name] = visiting
+		stack = append(stack, name)
+
+		afters := append([]string{}, g[name]...)
+		sort.Strings(afters)
+		for _, after := range afters {
+			if _, ok := g[after]; !ok || after == name {
+				continue
+			}
+			switch state[after] {
+			case unvisited:
+				visit(after)
+			case visiting:
+				start := 0
+				for i, n := range stack {
+					if n == after {
+						start = i
+					}
+				}
+				cycle := rotateCycle(stack[start:])
+				if key := strings.Join(cycle, "\x00"); !seen[key] {
+					seen[key] = true
+					cycles = append(cycles, append(cycle, cycle[0]))
+				}
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		state[name] = visited
+	}
+
+	for _, name := range g.names() {
+		if state[name] == unvisited {
+			visit(name)
+		}
+	}
+
+	return cycles
+}
+
+// rotateCycle starts the cycle with its alphabetically first task,
+// so the same cycle found from different tasks looks the same.
+func rotateCycle(cycle []string) []string {
+	first := 0
+	for i, name := range cycle {
+		if name < cycle[first] {
+			first = i
+		}
+	}
+	return append(append([]string{}, cycle[first:]...), cycle[:first]...)
+}
+
+// redundantEdge is a task running after another one it already runs after through a longer path.
+type redundantEdge struct {
+	task  string
+	after string
+	via   []string
+}
+
+// redundantEdges returns the edges that are not part of the transitive reduction.
+// The graph must not contain cycles.
+func (g taskGraph) redundantEdges() []redundantEdge {
+	edges := []redundantEdge{}
+	for _, name := range g.names() {
+		afters := append([]string{}, g[name]...)
+		sort.Strings(afters)
+
+		for _, after := range afters {
+			for _, other := range afters {
+				if other == after || other == name {
+					continue
+				}
+				if path := g.shortestPath(other, after); path != nil {
+					edges = append(edges, redundantEdge{
+						task:  name,
+						after: after,
+						via:   append([]string{name}, path...),
+					})
+					break
+				}
+			}
+		}
+	}
+	return edges
+}
+
+// shortestPath returns the shortest chain of tasks from one to another one it runs after,
+// or nil if there is none.
+func (g taskGraph) shortestPath(from, to string) []string {
+	via := map[string]string{from: ""}
+	queue := []string{from}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+
+		if name == to {
+			path := []string{}
+			for n := to; n != ""; n = via[n] {
+				path = append([]string{n}, path...)
+			}
+			return path
+		}
+
+		afters := append([]string{}, g[name]...)
+		sort.Strings(afters)
+		for _, after := range afters {
+			if _, ok := via[after]; !ok {
+				via[after] = name
+				queue = append(queue, after)
+			}
+		}
+	}
+	return nil
+}
+
+func quoteAll(names []string, sep string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = fmt.Sprintf("%q", name)
+	}
+	return strings.Join(quoted, sep)
+}
+
+// validationError is returned when the graph has errors that prevent running tasks.
+type validationError struct {
+	problems []graphProblem
+}
+
+func (e *validationError) Error() string {
+	lines := []string{"invalid task dependencies:"}
+	for _, problem := range e.problems {
+		if problem.Severity == problemError {
+			lines = append(lines, "  "+problem.Message)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// check returns a validationError if the graph has any errors.
+// Unlike validate, it doesn't look for redundant dependencies,
+// as that takes long for large graphs and never prevents a run.
+func (g taskGraph) check() error {
+	if problems := g.errors(); len(problems) > 0 {
+		return &validationError{problems: problems}
+	}
+	return nil
+}
+
+func writeProblems(w io.Writer, problems []graphProblem) {
+	errs, warnings := 0, 0
+	for _, problem := range problems {
+		fmt.Fprintf(w, "%s: %s\n", problem.Severity, problem.Message)
+		if problem.Severity == problemError {
+			errs++
+		} else {
+			warnings++
+		}
+	}
+	fmt.Fprintf(w, "%s, %s\n", plural(errs, "error"), plural(warnings, "warning"))
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+func (v Validate) start() error {
+	dag, err := cachedDag(v.DagFlake, v.StateDir, v.Refresh)
+	if err != nil {
+		return err
+	}
+
+	problems := taskGraph(dag).validate()
+
+	if v.JSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(problems); err != nil {
+			return err
+		}
+	} else {
+		writeProblems(os.Stdout, problems)
+	}
+
+	failed := 0
+	for _, problem := range problems {
+		if problem.Severity == problemError || v.Strict {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("found %s", plural(failed, "problem"))
+	}
+
+	return nil
+}
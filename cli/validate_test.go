@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckOnlyFailsOnErrors(t *testing.T) {
+	redundant := taskGraph{"a": {}, "b": {"a"}, "c": {"a", "b"}}
+	if err := redundant.check(); err != nil {
+		t.Errorf("redundant dependencies shouldn't prevent a run: %s", err)
+	}
+	if problems := redundant.validate(); len(problems) != 1 || problems[0].Kind != "redundant" {
+		t.Errorf("expected validate to warn about the redundant dependency, got %+v", problems)
+	}
+
+	if problems := (taskGraph{"a": {"a", "b"}, "b": {}}).validate(); len(problems) != 1 || problems[0].Kind != "self" {
+		t.Errorf("expected only the self dependency, got %+v", problems)
+	}
+
+	for name, graph := range map[string]taskGraph{
+		"cycle":   {"a": {"b"}, "b": {"a"}},
+		"self":    {"a": {"a"}},
+		"unknown": {"a": {"tdy"}, "tidy": {}},
+	} {
+		var invalid *validationError
+		if err := graph.check(); !errors.As(err, &invalid) || invalid.problems[0].Kind != name {
+			t.Errorf("expected a %s error, got %v", name, err)
+		}
+	}
+}
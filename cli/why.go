@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+func (w Why) start() error {
+	dag, err := cachedDag(w.DagFlake, w.StateDir, w.Refresh)
+	if err != nil {
+		return err
+	}
+	graph := taskGraph(dag)
+
+	for _, name := range []string{w.Target, w.Task} {
+		if err := graph.lookup(name); err != nil {
+			return err
+		}
+	}
+
+	paths := graph.paths(w.Target, w.Task)
+	if len(paths) == 0 {
+		return fmt.Errorf("%s does not run after %s", w.Target, w.Task)
+	}
+
+	if len(paths) == 1 {
+		fmt.Fprintf(os.Stdout, "%s runs after %s through 1 path:\n", w.Target, w.Task)
+	} else {
+		fmt.Fprintf(os.Stdout, "%s runs after %s through %d paths:\n", w.Target, w.Task, len(paths))
+	}
+	for _, path := range paths {
+		fmt.Fprintf(os.Stdout, "  %s\n", strings.Join(path, " → "))
+	}
+
+	return nil
+}
+
+// paths returns every chain of tasks from one task to another one it runs after,
+// shortest first.
+func (g taskGraph) paths(from, to string) [][]string {
+	paths := [][]string{}
+	onPath := map[string]bool{}
+
+	var walk func([]string)
+	walk = func(path []string) {
+		name := path[len(path)-1]
+		// a task only runs after itself through a cycle
+		if name == to && len(path) > 1 {
+			paths = append(paths, append([]string{}, path...))
+			return
+		}
+		if onPath[name] {
+			// only possible with a cycle
+			return
+		}
+		onPath[name] = true
+		defer delete(onPath, name)
+
+		afters := append([]string{}, g[name]...)
+		sort.Strings(afters)
+		for _, after := range afters {
+			walk(append(path, after))
+		}
+	}
+	walk([]string{from})
+
+	sort.SliceStable(paths, func(i, j int) bool { return len(paths[i]) < len(paths[j]) })
+	return paths
+}
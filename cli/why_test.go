@@ -0,0 +1,32 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPaths(t *testing.T) {
+	for _, tc := range []struct {
+		from, to string
+		expected [][]string
+	}{
+		{"d", "a", [][]string{{"d", "b", "a"}, {"d", "c", "a"}}},
+		{"d", "b", [][]string{{"d", "b"}}},
+		// shortest first
+		{"f", "a", [][]string{{"f", "a"}, {"f", "d", "b", "a"}, {"f", "d", "c", "a"}}},
+		// no path, in either direction
+		{"d", "e", [][]string{}},
+		{"a", "d", [][]string{}},
+		// the target itself
+		{"d", "d", [][]string{}},
+	} {
+		graph := taskGraph{"a": {}, "b": {"a"}, "c": {"a"}, "d": {"b", "c"}, "e": {}, "f": {"d", "a"}}
+		if got := graph.paths(tc.from, tc.to); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("expected paths from %s to %s to be %v, got %v", tc.from, tc.to, tc.expected, got)
+		}
+	}
+
+	if got := (taskGraph{"a": {"a"}}).paths("a", "a"); !reflect.DeepEqual(got, [][]string{{"a", "a"}}) {
+		t.Errorf("expected a task running after itself to have a path to itself, got %v", got)
+	}
+}
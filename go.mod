@@ -7,9 +7,12 @@ require (
 	github.com/charmbracelet/bubbletea v0.20.0
 	github.com/charmbracelet/lipgloss v0.5.0
 	github.com/goombaio/dag v0.0.0-20181006234417-a8874b1f72ff
+	github.com/muesli/termenv v0.11.1-0.20220212125758-44cd13922739
 	github.com/pkg/errors v0.9.1
 	github.com/plouc/textree v1.0.0
 	github.com/rs/zerolog v1.26.1
+	golang.org/x/sys v0.0.0-20210809222454-d867a43fc93e
+	golang.org/x/term v0.0.0-20210422114643-f5beecf764ed
 )
 
 require (
@@ -23,11 +26,8 @@ require (
 	github.com/mattn/go-runewidth v0.0.13 // indirect
 	github.com/muesli/ansi v0.0.0-20211018074035-2e021307bc4b // indirect
 	github.com/muesli/reflow v0.3.0 // indirect
-	github.com/muesli/termenv v0.11.1-0.20220212125758-44cd13922739 // indirect
 	github.com/plouc/gosnap v0.0.0-20180714070049-61403c2f226e // indirect
 	github.com/rivo/uniseg v0.2.0 // indirect
 	github.com/sergi/go-diff v1.2.0 // indirect
-	golang.org/x/sys v0.0.0-20210809222454-d867a43fc93e // indirect
-	golang.org/x/term v0.0.0-20210422114643-f5beecf764ed // indirect
 	gopkg.in/src-d/go-git.v4 v4.13.1 // indirect
 )
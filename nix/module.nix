@@ -215,6 +215,14 @@
     options = {
       enable = lib.mkEnableOption "the task" // {default = true;};
 
+      description = mkOption {
+        type = str;
+        default = "";
+        description = ''
+          A short explanation of what the task is for.
+        '';
+      };
+
       after = mkOption {
         type = listOf str;
         default = [];
@@ -1400,8 +1408,13 @@ in {
               RUN_SPEC =
                 "@"
                 + pkgs.writeText "run-spec.json" (__toJSON {
-                  inherit (moduleConfig) dag;
-                  bin = __mapAttrs (n: v: "${v.unwrapped.run}/bin/${n}-unwrapped") enabledTasks;
+                  version = 1;
+                  tasks =
+                    __mapAttrs (n: v: {
+                      inherit (v) after description;
+                      bin = "${v.unwrapped.run}/bin/${n}-unwrapped";
+                    })
+                    enabledTasks;
                 });
               MODE = "passthrough";
               RUNTIME = "unwrapped";